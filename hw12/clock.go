@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the data signers and OverheatLock.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// SignerClock can be replaced by a *FakeClock in tests.
+var SignerClock Clock = realClock{}
+
+type sleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+// FakeClock is a virtual clock: Sleep blocks until the clock is moved past
+// the wake-up time with Advance or by AutoAdvance.
+type FakeClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	sleepers []*sleeper
+	// gets a value every time somebody goes to sleep
+	slept chan struct{}
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{
+		now:   start,
+		slept: make(chan struct{}, 1),
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	s := &sleeper{until: c.now.Add(d), wake: make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.mu.Unlock()
+
+	select {
+	case c.slept <- struct{}{}:
+	default:
+	}
+	<-s.wake
+}
+
+// Advance moves the clock forward and wakes up everybody whose time has come.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.advanceTo(c.Now().Add(d))
+}
+
+func (c *FakeClock) advanceTo(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	left := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if s.until.After(c.now) {
+			left = append(left, s)
+			continue
+		}
+		close(s.wake)
+	}
+	c.sleepers = left
+}
+
+func (c *FakeClock) nextWakeup() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sleepers) == 0 {
+		return time.Time{}, false
+	}
+	next := c.sleepers[0].until
+	for _, s := range c.sleepers[1:] {
+		if s.until.Before(next) {
+			next = s.until
+		}
+	}
+	return next, true
+}
+
+// AutoAdvance jumps to the nearest wake-up time as soon as nobody has gone
+// to sleep for settle of real time, i.e. when all the goroutines that were
+// going to sleep at the current virtual moment are already sleeping.
+// A too short settle can only make the virtual time longer, never shorter.
+func (c *FakeClock) AutoAdvance(settle time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.slept:
+			case <-time.After(settle):
+				if next, ok := c.nextWakeup(); ok {
+					c.advanceTo(next)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
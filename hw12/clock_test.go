@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withFakeClock puts the signers on a virtual clock for the test, the
+// functions the test replaces are put back after it
+func withFakeClock(t *testing.T) *FakeClock {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	stop := clock.AutoAdvance(5 * time.Millisecond)
+
+	prevClock := SignerClock
+	prevLock, prevUnlock := OverheatLock, OverheatUnlock
+	prevMd5, prevCrc32 := DataSignerMd5, DataSignerCrc32
+
+	SignerClock = clock
+
+	t.Cleanup(func() {
+		stop()
+		SignerClock = prevClock
+		OverheatLock, OverheatUnlock = prevLock, prevUnlock
+		DataSignerMd5, DataSignerCrc32 = prevMd5, prevCrc32
+	})
+	return clock
+}
+
+func TestFakeClockAdvance(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	start := clock.Now()
+	var woke uint32
+	go func() {
+		clock.Sleep(time.Second)
+		atomic.StoreUint32(&woke, 1)
+	}()
+
+	for {
+		if _, ok := clock.nextWakeup(); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(999 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadUint32(&woke) != 0 {
+		t.Errorf("woke up too early")
+	}
+	clock.Advance(time.Millisecond)
+	for i := 0; i < 100 && atomic.LoadUint32(&woke) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadUint32(&woke) == 0 {
+		t.Errorf("did not wake up")
+	}
+	if got := clock.Now().Sub(start); got != time.Second {
+		t.Errorf("expected 1s elapsed, got %s", got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTooManyFailures is returned by ExecutePipelineWithConfig when the
+// number of rejected items exceeds one of the configured thresholds.
+var ErrTooManyFailures = errors.New("too many failed items")
+
+// DeadLetter describes an item that a stage refused to process.
+type DeadLetter struct {
+	Stage    string
+	Item     interface{}
+	Err      error
+	FailedAt time.Time // when the stage rejected the item, by SignerClock
+	RoutedAt time.Time // when the pipeline handed it to the sink
+}
+
+func (d *DeadLetter) Error() string {
+	return fmt.Sprintf("%s: %v (item %#v)", d.Stage, d.Err, d.Item)
+}
+
+func (d *DeadLetter) Unwrap() error {
+	return d.Err
+}
+
+// Reject is called by a stage instead of panicking on a bad item.
+// The item is sent downstream wrapped in a *DeadLetter, the pipeline
+// intercepts it before it reaches the next stage.
+func Reject(out chan interface{}, stage string, item interface{}, err error) {
+	out <- &DeadLetter{
+		Stage:    stage,
+		Item:     item,
+		Err:      err,
+		FailedAt: SignerClock.Now(),
+	}
+}
+
+type PipelineConfig struct {
+	// DeadLetters receives every rejected item. It must be drained by the
+	// caller (or be buffered enough). With nil rejected items are dropped
+	// silently, they are not printed or logged and only count for the
+	// limits below.
+	DeadLetters chan<- *DeadLetter
+	// MaxFailures aborts the run once more items than this have been
+	// rejected in total, the context of the stages is cancelled then.
+	// 0 means no limit.
+	MaxFailures int
+	// MaxStageFailures is the same limit applied per stage name.
+	MaxStageFailures map[string]int
+}
+
+type deadLetterRouter struct {
+	cfg    PipelineConfig
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	total    int
+	byStage  map[string]int
+	abortErr error
+}
+
+func newDeadLetterRouter(cfg PipelineConfig, cancel context.CancelFunc) *deadLetterRouter {
+	return &deadLetterRouter{
+		cfg:     cfg,
+		cancel:  cancel,
+		byStage: map[string]int{},
+	}
+}
+
+// route sits between two stages: dead letters go to the sink, everything
+// else is passed on. After an abort (or after the last stage, when out is
+// nil) the input is only drained so that the upstream stages can finish.
+func (r *deadLetterRouter) route(in, out chan interface{}) {
+	for v := range in {
+		if d, ok := v.(*DeadLetter); ok {
+			r.put(d)
+			continue
+		}
+		if out == nil || r.aborted() {
+			continue
+		}
+		out <- v
+	}
+}
+
+func (r *deadLetterRouter) put(d *DeadLetter) {
+	d.RoutedAt = SignerClock.Now()
+
+	r.mu.Lock()
+	r.total++
+	r.byStage[d.Stage]++
+	if r.abortErr == nil {
+		if r.cfg.MaxFailures > 0 && r.total > r.cfg.MaxFailures {
+			r.abortErr = fmt.Errorf("%w: %d items rejected, max %d", ErrTooManyFailures, r.total, r.cfg.MaxFailures)
+		} else if max, ok := r.cfg.MaxStageFailures[d.Stage]; ok && max > 0 && r.byStage[d.Stage] > max {
+			r.abortErr = fmt.Errorf("%w: %d items rejected by %s, max %d", ErrTooManyFailures, r.byStage[d.Stage], d.Stage, max)
+		}
+		if r.abortErr != nil {
+			r.cancel()
+		}
+	}
+	r.mu.Unlock()
+
+	if r.cfg.DeadLetters != nil {
+		r.cfg.DeadLetters <- d
+	}
+}
+
+func (r *deadLetterRouter) aborted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.abortErr != nil
+}
+
+func (r *deadLetterRouter) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.abortErr
+}
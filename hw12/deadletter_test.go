@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterSingleHash(t *testing.T) {
+	deadLetters := make(chan *DeadLetter, 10)
+	var passed uint32
+
+	err := ExecutePipelineWithConfig(PipelineConfig{DeadLetters: deadLetters},
+		job(func(in, out chan interface{}) {
+			out <- "not a number"
+			out <- 3.14
+		}),
+		job(SingleHash),
+		job(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddUint32(&passed, 1)
+			}
+		}),
+	)
+	close(deadLetters)
+
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if passed != 0 {
+		t.Errorf("rejected items reached next stage: %d", passed)
+	}
+	got := 0
+	for d := range deadLetters {
+		got++
+		if d.Stage != "SingleHash" || d.Err == nil {
+			t.Errorf("bad dead letter: %#v", d)
+		}
+		if d.FailedAt.IsZero() || d.RoutedAt.Before(d.FailedAt) {
+			t.Errorf("bad timestamps: %v %v", d.FailedAt, d.RoutedAt)
+		}
+	}
+	if got != 2 {
+		t.Errorf("expected 2 dead letters, got %d", got)
+	}
+}
+
+func TestDeadLetterContinue(t *testing.T) {
+	deadLetters := make(chan *DeadLetter, 10)
+	var sum uint32
+
+	err := ExecutePipelineWithConfig(PipelineConfig{DeadLetters: deadLetters},
+		job(func(in, out chan interface{}) {
+			for i := uint32(1); i <= 4; i++ {
+				out <- i
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for v := range in {
+				if v.(uint32)%2 == 0 {
+					Reject(out, "odd", v, errors.New("even"))
+					continue
+				}
+				out <- v
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for v := range in {
+				atomic.AddUint32(&sum, v.(uint32))
+			}
+		}),
+	)
+	close(deadLetters)
+
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if sum != 1+3 {
+		t.Errorf("expected only odd values to pass, sum = %d", sum)
+	}
+	if len(deadLetters) != 2 {
+		t.Errorf("expected 2 dead letters, got %d", len(deadLetters))
+	}
+}
+
+func TestDeadLetterMaxFailures(t *testing.T) {
+	cases := []PipelineConfig{
+		{MaxFailures: 3},
+		{MaxStageFailures: map[string]int{"reject all": 3}},
+	}
+	for _, cfg := range cases {
+		deadLetters := make(chan *DeadLetter, 10)
+		cfg.DeadLetters = deadLetters
+		err := ExecutePipelineWithConfig(cfg,
+			job(func(in, out chan interface{}) {
+				for i := 0; i < 10; i++ {
+					out <- i
+				}
+			}),
+			job(func(in, out chan interface{}) {
+				for v := range in {
+					Reject(out, "reject all", v, errors.New("rejected"))
+				}
+			}),
+		)
+		if !errors.Is(err, ErrTooManyFailures) {
+			t.Errorf("expected ErrTooManyFailures, got %v", err)
+		}
+	}
+}
+
+func TestDeadLetterAbortStopsStages(t *testing.T) {
+	var produced uint32
+	done := make(chan error, 1)
+	go func() {
+		// no sink, the rejected items are only counted
+		done <- ExecutePipelineContext(context.Background(), PipelineConfig{MaxFailures: 3},
+			Stage(func(ctx context.Context, in, out chan interface{}) {
+				for i := 0; ; i++ {
+					select {
+					case out <- i:
+						atomic.AddUint32(&produced, 1)
+					case <-ctx.Done():
+						return
+					}
+				}
+			}),
+			Stage(func(ctx context.Context, in, out chan interface{}) {
+				for v := range in {
+					Reject(out, "reject all", v, errors.New("rejected"))
+				}
+			}),
+		)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTooManyFailures) {
+			t.Errorf("expected ErrTooManyFailures, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run did not stop after the limit")
+	}
+	if n := atomic.LoadUint32(&produced); n > 10 {
+		t.Errorf("expected the source to stop soon after 4 rejects, it produced %d items", n)
+	}
+}
+
+func TestDeadLetterContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := ExecutePipelineContext(ctx, PipelineConfig{},
+		Stage(func(ctx context.Context, in, out chan interface{}) {
+			<-ctx.Done()
+		}),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDeadLetterFakeClock(t *testing.T) {
+	clock := withFakeClock(t)
+	start := clock.Now()
+	deadLetters := make(chan *DeadLetter, 1)
+
+	err := ExecutePipelineWithConfig(PipelineConfig{DeadLetters: deadLetters},
+		job(func(in, out chan interface{}) {
+			SignerClock.Sleep(time.Second)
+			Reject(out, "late", 1, errors.New("rejected"))
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := <-deadLetters
+	if !d.FailedAt.Equal(start.Add(time.Second)) || !d.RoutedAt.Equal(d.FailedAt) {
+		t.Errorf("expected both timestamps at %s, got %s and %s", start.Add(time.Second), d.FailedAt, d.RoutedAt)
+	}
+}
@@ -19,6 +19,7 @@ import (
 */
 
 func TestByIlia(t *testing.T) {
+	clock := withFakeClock(t)
 
 	var recieved uint32
 	freeFlowJobs := []job{
@@ -30,7 +31,7 @@ func TestByIlia(t *testing.T) {
 		job(func(in, out chan interface{}) {
 			for val := range in {
 				out <- val.(uint32) * 3
-				time.Sleep(time.Millisecond * 100)
+				SignerClock.Sleep(time.Millisecond * 100)
 			}
 		}),
 		job(func(in, out chan interface{}) {
@@ -41,11 +42,11 @@ func TestByIlia(t *testing.T) {
 		}),
 	}
 
-	start := time.Now()
+	start := clock.Now()
 
 	ExecutePipeline(freeFlowJobs...)
 
-	end := time.Since(start)
+	end := clock.Now().Sub(start)
 
 	expectedTime := time.Millisecond * 350
 
@@ -1,3 +1,10 @@
 module github.com/vmarunin/golangcourse/hw12
 
 go 1.19
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0
+	golang.org/x/crypto v0.14.0
+)
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+const DefaultMultiHashCount = 6
+
+// HashSigner is a set of hash functions used by the hash chain.
+// Checksum plays the role of DataSignerCrc32, Digest the role of DataSignerMd5.
+type HashSigner interface {
+	Checksum(data string) string
+	Digest(data string) string
+}
+
+// crc32Signer is the original crc32/md5 signer. It calls the package level
+// DataSigner* variables on every call so they can still be substituted.
+type crc32Signer struct{}
+
+func (crc32Signer) Checksum(data string) string {
+	return DataSignerCrc32(data)
+}
+
+// Digest is serialized: DataSignerMd5 overheats when called concurrently
+func (crc32Signer) Digest(data string) string {
+	md5Mutex.Lock()
+	defer md5Mutex.Unlock()
+	return DataSignerMd5(data)
+}
+
+// hashSigner uses one hash.Hash for both roles, hex encoded.
+// DataSignerSalt is appended to the data like in the original signers.
+type hashSigner struct {
+	newHash func() hash.Hash
+}
+
+func (s hashSigner) sum(data string) string {
+	h := s.newHash()
+	h.Write([]byte(data + DataSignerSalt))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s hashSigner) Checksum(data string) string { return s.sum(data) }
+func (s hashSigner) Digest(data string) string   { return s.sum(data) }
+
+// hmacSigner keys HMAC-SHA256 with DataSignerSalt instead of appending it.
+type hmacSigner struct{}
+
+func (hmacSigner) sum(data string) string {
+	h := hmac.New(sha256.New, []byte(DataSignerSalt))
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s hmacSigner) Checksum(data string) string { return s.sum(data) }
+func (s hmacSigner) Digest(data string) string   { return s.sum(data) }
+
+func newBlake2b() hash.Hash {
+	h, _ := blake2b.New256(nil) // err only for a key longer than 64 bytes
+	return h
+}
+
+var (
+	Crc32Signer      HashSigner = crc32Signer{}
+	SHA256Signer     HashSigner = hashSigner{newHash: sha256.New}
+	XXHashSigner     HashSigner = hashSigner{newHash: func() hash.Hash { return xxhash.New() }}
+	Blake2bSigner    HashSigner = hashSigner{newHash: newBlake2b}
+	HMACSHA256Signer HashSigner = hmacSigner{}
+)
+
+// Signers lists available signers by name, e.g. for a command line flag.
+var Signers = map[string]HashSigner{
+	"crc32":       Crc32Signer,
+	"sha256":      SHA256Signer,
+	"xxhash":      XXHashSigner,
+	"blake2b":     Blake2bSigner,
+	"hmac-sha256": HMACSHA256Signer,
+}
+
+// HashChain builds SingleHash and MultiHash stages over a signer:
+// SingleHash(data) = Checksum(data)+"~"+Checksum(Digest(data)),
+// MultiHash(data) = concatenation of Checksum(th+data), th = 0..MultiHashCount-1.
+type HashChain struct {
+	Signer         HashSigner
+	MultiHashCount int
+}
+
+func NewHashChain(signer HashSigner, multiHashCount int) *HashChain {
+	if multiHashCount <= 0 {
+		multiHashCount = DefaultMultiHashCount
+	}
+	return &HashChain{
+		Signer:         signer,
+		MultiHashCount: multiHashCount,
+	}
+}
+
+// DefaultHashChain is used by the SingleHash and MultiHash jobs.
+var DefaultHashChain = NewHashChain(Crc32Signer, DefaultMultiHashCount)
+
+func (hc *HashChain) checksumHelper(in string, out []string, outIdx int, wg *sync.WaitGroup) {
+	out[outIdx] = hc.Signer.Checksum(in)
+	wg.Done()
+}
+
+func (hc *HashChain) singleHashWorker(s string, out chan interface{}, wg *sync.WaitGroup) {
+	digest := hc.Signer.Digest(s)
+	wg2 := &sync.WaitGroup{}
+	crc := make([]string, 2)
+	wg2.Add(2)
+	go hc.checksumHelper(s, crc, 0, wg2)
+	go hc.checksumHelper(digest, crc, 1, wg2)
+	wg2.Wait()
+	out <- crc[0] + "~" + crc[1]
+	wg.Done()
+}
+
+func (hc *HashChain) SingleHash(in, out chan interface{}) {
+	wg := &sync.WaitGroup{}
+	for v := range in {
+		n, ok := v.(int)
+		if !ok {
+			Reject(out, "SingleHash", v, errNotInt(v))
+			continue
+		}
+		wg.Add(1)
+		go hc.singleHashWorker(strconv.Itoa(n), out, wg)
+	}
+	wg.Wait()
+}
+
+func (hc *HashChain) multiHashWorker(s string, out chan interface{}, wg *sync.WaitGroup) {
+	wg2 := &sync.WaitGroup{}
+	crc := make([]string, hc.MultiHashCount)
+	for i := 0; i < hc.MultiHashCount; i++ {
+		wg2.Add(1)
+		go hc.checksumHelper(strconv.Itoa(i)+s, crc, i, wg2)
+	}
+	wg2.Wait()
+	out <- strings.Join(crc, "")
+	wg.Done()
+}
+
+func (hc *HashChain) MultiHash(in, out chan interface{}) {
+	wg := &sync.WaitGroup{}
+	for v := range in {
+		s, ok := v.(string)
+		if !ok {
+			Reject(out, "MultiHash", v, errNotString(v))
+			continue
+		}
+		wg.Add(1)
+		go hc.multiHashWorker(s, out, wg)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func runHashChain(hc *HashChain, inputData []int) string {
+	result := ""
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, v := range inputData {
+				out <- v
+			}
+		}),
+		job(hc.SingleHash),
+		job(hc.MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+	return result
+}
+
+// crc32 signer golden output is checked by TestSigner
+func TestHashChainGolden(t *testing.T) {
+	golden := map[string]string{
+		"sha256":      "10e1a4a05c333561a6418aa65f8f6f3262a1125ea573b0085a5f7ed951ed3d77f9277517804195b7044abc58a767ef132a85662ffe4b240f265d75f2fda81c5f_fff47a311195310b96a15f1cade5735ce85707233d0c771fbee66ce92bbcf8daa3e7e8ebfa8b4b60275baa377aa393f83bc81ac708825fae0486bf1c140aec18",
+		"xxhash":      "510c7f04dbc1286426e40b7109f7e2cc_af626e518f02bbe92fbccc93af70547b",
+		"blake2b":     "7f540caa6038f72f99ba124769963529af7b67b8dcff2d67e881c230b00e96a0c96d455726fc820c309f50d677b7c7debc02c91d7093c9aa3320b829bd67e85c_b32778a2de4c75a0ba4d7e5b42533cc63d47707754e01584fccfb134267bd9339a96479ac3ac89c1778285aa6ea69aedf6171f00843108c76df8fc49f4be9ee8",
+		"hmac-sha256": "0a8bdc669c573c93aafe093e483030b8e970d7187067da9bfd503b3e75eab2502534b8d0d6ff84207fbcae3f3bb3096bd9fa50b3d12de2717a496f05f53e5c94_6275379a8d2018b533db0726901c22800db8bc16ba01c8b0077365802e3053a676fb5cb51474b8c65d21f70c329a9e17896b91e8de4e81af66b248d64ff5ed2b",
+	}
+	for name, expected := range golden {
+		result := runHashChain(NewHashChain(Signers[name], 2), []int{0, 1})
+		if result != expected {
+			t.Errorf("%s: results not match\nGot: %v\nExpected: %v", name, result, expected)
+		}
+	}
+}
+
+func TestHashChainMultiHashCount(t *testing.T) {
+	for _, count := range []int{1, 3, 12} {
+		result := runHashChain(NewHashChain(SHA256Signer, count), []int{7})
+		if len(result) != count*64 {
+			t.Errorf("fan-out %d: expected %d hex chars, got %d", count, count*64, len(result))
+		}
+	}
+}
+
+func TestHashChainSalt(t *testing.T) {
+	old := DataSignerSalt
+	t.Cleanup(func() { DataSignerSalt = old })
+	DataSignerSalt = ""
+	plain := runHashChain(NewHashChain(HMACSHA256Signer, 1), []int{1})
+	DataSignerSalt = "pepper"
+	salted := runHashChain(NewHashChain(HMACSHA256Signer, 1), []int{1})
+	if plain == salted {
+		t.Errorf("DataSignerSalt is not used as HMAC key")
+	}
+}
@@ -1,90 +1,106 @@
-package main
-
-import (
-	"sort"
-	"strconv"
-	"strings"
-	"sync"
-)
-
-// сюда писать код
-
-var md5Mutex *sync.Mutex = &sync.Mutex{}
-
-func crc32Helper(in string, out *[]string, outIdx int, wg *sync.WaitGroup) {
-	(*out)[outIdx] = DataSignerCrc32(in)
-	wg.Done()
-}
-
-func SingleHashWorker(s string, out chan interface{}, wg *sync.WaitGroup) {
-	md5Mutex.Lock()
-	md5s := DataSignerMd5(s)
-	md5Mutex.Unlock()
-	wg2 := &sync.WaitGroup{}
-	crc := make([]string, 2)
-	wg2.Add(1)
-	go crc32Helper(s, &crc, 0, wg2)
-	wg2.Add(1)
-	go crc32Helper(md5s, &crc, 1, wg2)
-	wg2.Wait()
-	out <- crc[0] + "~" + crc[1]
-	wg.Done()
-
-}
-func SingleHash(in, out chan interface{}) {
-	wg := &sync.WaitGroup{}
-	for v := range in {
-		s := strconv.Itoa(v.(int))
-		wg.Add(1)
-		go SingleHashWorker(s, out, wg)
-	}
-	wg.Wait()
-}
-
-func MultiHashWorker(s string, out chan interface{}, wg *sync.WaitGroup) {
-	wg2 := &sync.WaitGroup{}
-	crc := make([]string, 6)
-	for i := 0; i <= 5; i++ {
-		wg2.Add(1)
-		go crc32Helper(string(byte(i)+'0')+s, &crc, i, wg2)
-	}
-	wg2.Wait()
-	out <- strings.Join(crc, "")
-	wg.Done()
-
-}
-func MultiHash(in, out chan interface{}) {
-	wg := &sync.WaitGroup{}
-	for v := range in {
-		s := v.(string)
-		wg.Add(1)
-		go MultiHashWorker(s, out, wg)
-	}
-	wg.Wait()
-}
-
-func CombineResults(in, out chan interface{}) {
-	data := []string{}
-	for v := range in {
-		data = append(data, v.(string))
-	}
-	sort.Strings(data)
-	out <- strings.Join(data, "_")
-}
-
-func ExecutePipeline(jobs ...job) {
-	prevCh := make(chan interface{})
-	wg := &sync.WaitGroup{}
-
-	for _, j := range jobs {
-		currCh := make(chan interface{})
-		wg.Add(1)
-		go func(j job, in, out chan interface{}) {
-			defer close(out)
-			defer wg.Done()
-			j(in, out)
-		}(j, prevCh, currCh)
-		prevCh = currCh
-	}
-	wg.Wait()
-}
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// сюда писать код
+
+var md5Mutex *sync.Mutex = &sync.Mutex{}
+
+func errNotInt(v interface{}) error {
+	return fmt.Errorf("expected int, got %T", v)
+}
+
+func errNotString(v interface{}) error {
+	return fmt.Errorf("expected string, got %T", v)
+}
+
+func SingleHash(in, out chan interface{}) {
+	DefaultHashChain.SingleHash(in, out)
+}
+
+func MultiHash(in, out chan interface{}) {
+	DefaultHashChain.MultiHash(in, out)
+}
+
+func CombineResults(in, out chan interface{}) {
+	data := []string{}
+	for v := range in {
+		s, ok := v.(string)
+		if !ok {
+			Reject(out, "CombineResults", v, errNotString(v))
+			continue
+		}
+		data = append(data, s)
+	}
+	sort.Strings(data)
+	out <- strings.Join(data, "_")
+}
+
+// ExecutePipeline runs jobs with no DeadLetters and no limits, items
+// rejected by a stage are dropped silently
+func ExecutePipeline(jobs ...job) {
+	ExecutePipelineWithConfig(PipelineConfig{}, jobs...)
+}
+
+// Stage is a job that can stop early: ctx is cancelled when the run is
+// aborted by a failure threshold or the caller's context is done. A stage
+// producing items without end must watch it, the run never returns otherwise.
+type Stage func(ctx context.Context, in, out chan interface{})
+
+// ExecutePipelineWithConfig runs jobs like ExecutePipeline, but items rejected
+// by a stage are routed to cfg.DeadLetters instead of the next stage.
+// It returns ErrTooManyFailures if the run was aborted by a threshold.
+func ExecutePipelineWithConfig(cfg PipelineConfig, jobs ...job) error {
+	stages := make([]Stage, len(jobs))
+	for i, j := range jobs {
+		j := j
+		stages[i] = func(ctx context.Context, in, out chan interface{}) {
+			j(in, out)
+		}
+	}
+	return ExecutePipelineContext(context.Background(), cfg, stages...)
+}
+
+// ExecutePipelineContext runs stages like ExecutePipelineWithConfig with a
+// context the stages watch. It returns ErrTooManyFailures if the run was
+// aborted by a threshold and ctx.Err() if ctx was done before the end.
+func ExecutePipelineContext(ctx context.Context, cfg PipelineConfig, stages ...Stage) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	router := newDeadLetterRouter(cfg, cancel)
+	prevCh := make(chan interface{})
+	wg := &sync.WaitGroup{}
+
+	for i, stage := range stages {
+		stageOut := make(chan interface{})
+		var currCh chan interface{}
+		if i < len(stages)-1 {
+			currCh = make(chan interface{})
+		}
+		wg.Add(2)
+		go func(stage Stage, in, out chan interface{}) {
+			defer close(out)
+			defer wg.Done()
+			stage(ctx, in, out)
+		}(stage, prevCh, stageOut)
+		go func(in, out chan interface{}) {
+			defer wg.Done()
+			if out != nil {
+				defer close(out)
+			}
+			router.route(in, out)
+		}(stageOut, currCh)
+		prevCh = currCh
+	}
+	wg.Wait()
+	if err := router.err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// The columnar format keeps the users of a users file by column:
+//
+//	magic, version byte
+//	rows, dictionary size (uvarints)
+//	dictionary: the distinct browsers as length-prefixed strings
+//	a block per field in field order: uvarint block size, then per row
+//	  strings: uvarint length, bytes
+//	  browsers: uvarint count, uvarint dictionary ids
+//
+// Blocks are sized so that a reader can find them without decoding the
+// previous ones. Null and missing values are stored as empty ones.
+var columnarMagic = []byte("USRCOL")
+
+const columnarVersion = 1
+
+var ErrColumnarCorrupt = errors.New("corrupt columnar file")
+
+// IsColumnar reports if head is the start of a columnar file
+func IsColumnar(head []byte) bool {
+	return bytes.HasPrefix(head, columnarMagic)
+}
+
+// isColumnarFile reports if path is a columnar file
+func isColumnarFile(path string) bool {
+	if path == StdinPath {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	head := make([]byte, len(columnarMagic))
+	n, _ := io.ReadFull(file, head)
+	return IsColumnar(head[:n])
+}
+
+type columnWriter struct {
+	rows       int
+	dictionary map[string]uint64
+	browsers   [][]byte // dictionary in id order
+	columns    [fieldPhone + 1][]byte
+}
+
+func (w *columnWriter) add(rec *userRecord) {
+	w.rows++
+	for f := fieldName; f <= fieldPhone; f++ {
+		col := w.columns[f]
+		if f == fieldBrowsers {
+			col = appendUvarint(col, uint64(len(rec.browsers)))
+			for _, browser := range rec.browsers {
+				id, ok := w.dictionary[string(browser)]
+				if !ok {
+					id = uint64(len(w.browsers))
+					w.dictionary[string(browser)] = id
+					w.browsers = append(w.browsers, append([]byte{}, browser...))
+				}
+				col = appendUvarint(col, id)
+			}
+		} else {
+			col = appendString(col, rec.fieldValue(f))
+		}
+		w.columns[f] = col
+	}
+}
+
+// appendUvarint is binary.AppendUvarint, which needs go 1.19
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+func appendString(dst []byte, s []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func (w *columnWriter) writeTo(out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	header := append([]byte{}, columnarMagic...)
+	header = append(header, columnarVersion)
+	header = appendUvarint(header, uint64(w.rows))
+	header = appendUvarint(header, uint64(len(w.browsers)))
+	bw.Write(header)
+	for _, browser := range w.browsers {
+		bw.Write(appendString(nil, browser))
+	}
+	for _, col := range w.columns {
+		bw.Write(appendUvarint(nil, uint64(len(col))))
+		bw.Write(col)
+	}
+	return bw.Flush()
+}
+
+// ConvertColumnar reads json lines from r and writes them to w in the
+// columnar format. It returns the number of users.
+func ConvertColumnar(r io.Reader, w io.Writer) (int, error) {
+	cw := &columnWriter{dictionary: map[string]uint64{}}
+	fscanner := bufio.NewScanner(r)
+	rec := &userRecord{}
+	for i := 0; fscanner.Scan(); i++ {
+		if err := scanUser(fscanner.Bytes(), rec, allFields); err != nil {
+			return 0, &LineError{i + 1, err}
+		}
+		cw.add(rec)
+	}
+	if err := fscanner.Err(); err != nil {
+		return 0, err
+	}
+	return cw.rows, cw.writeTo(w)
+}
+
+// ConvertColumnarFile converts the users file at path (see OpenSource)
+// to a columnar file at out
+func ConvertColumnarFile(path, out string) (int, error) {
+	src, err := OpenSource(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	rows := 0
+	err = writeFileAtomic(out, func(w io.Writer) error {
+		rows, err = ConvertColumnar(src, w)
+		return err
+	})
+	return rows, err
+}
+
+// Columnar is a columnar file in memory. Scanned records point into it.
+type Columnar struct {
+	Rows       int
+	Dictionary [][]byte
+
+	columns [fieldPhone + 1][]byte
+}
+
+// OpenColumnar reads the columnar file at path
+func OpenColumnar(path string) (*Columnar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadColumnar(data)
+}
+
+// ReadColumnar parses a columnar file, the data must not be changed
+// while the result is used
+func ReadColumnar(data []byte) (*Columnar, error) {
+	if !IsColumnar(data) || len(data) <= len(columnarMagic) {
+		return nil, ErrColumnarCorrupt
+	}
+	if version := data[len(columnarMagic)]; version != columnarVersion {
+		return nil, ErrColumnarCorrupt
+	}
+	d := columnDecoder{data: data[len(columnarMagic)+1:]}
+	c := &Columnar{Rows: int(d.uvarint())}
+	size := d.uvarint()
+	if size > uint64(len(d.data)) {
+		return nil, ErrColumnarCorrupt
+	}
+	c.Dictionary = make([][]byte, size)
+	for i := range c.Dictionary {
+		c.Dictionary[i] = d.block()
+	}
+	for f := range c.columns {
+		c.columns[f] = d.block()
+	}
+	if d.err != nil || len(d.data) > 0 {
+		return nil, ErrColumnarCorrupt
+	}
+	return c, nil
+}
+
+// columnDecoder reads a block, the first error sticks
+type columnDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *columnDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = ErrColumnarCorrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// block reads a uvarint length and that many bytes
+func (d *columnDecoder) block() []byte {
+	size := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if size > uint64(len(d.data)) {
+		d.err = ErrColumnarCorrupt
+		return nil
+	}
+	b := d.data[:size:size]
+	d.data = d.data[size:]
+	return b
+}
+
+// Scan decodes the fields from want of every row into rec and calls fn
+func (c *Columnar) Scan(want fieldSet, fn func(i int, rec *userRecord) error) error {
+	decoders := [fieldPhone + 1]columnDecoder{}
+	for f := range decoders {
+		if want&field(f).bit() != 0 {
+			decoders[f].data = c.columns[f]
+		}
+	}
+	rec := &userRecord{}
+	for i := 0; i < c.Rows; i++ {
+		for f := fieldName; f <= fieldPhone; f++ {
+			if want&f.bit() == 0 {
+				continue
+			}
+			d := &decoders[f]
+			if f != fieldBrowsers {
+				rec.setField(f, d.block())
+				continue
+			}
+			count := d.uvarint()
+			if count > uint64(len(d.data)) {
+				return ErrColumnarCorrupt
+			}
+			rec.browsers = rec.browsers[:0]
+			for j := uint64(0); j < count; j++ {
+				id := d.uvarint()
+				if id >= uint64(len(c.Dictionary)) {
+					return ErrColumnarCorrupt
+				}
+				rec.browsers = append(rec.browsers, c.Dictionary[id])
+			}
+		}
+		for f := range decoders {
+			if decoders[f].err != nil {
+				return decoders[f].err
+			}
+		}
+		if err := fn(i, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search works like Search over the original users file
+func (c *Columnar) Search(q *Query, sink resultSink) error {
+	seenBrowsers := map[string]bool{}
+	err := c.Scan(q.fields|sinkFields(sink), func(i int, rec *userRecord) error {
+		q.seenBrowsers(rec, seenBrowsers)
+		if !q.root.match(rec) {
+			return nil
+		}
+		return sink.Found(i, rec)
+	})
+	if err != nil {
+		return err
+	}
+	return sink.Done(len(seenBrowsers))
+}
+
+// SearchColumnar gives the same results as SearchFile over the users file
+// the columnar file at path was converted from
+func SearchColumnar(path string, q *Query, sink resultSink) error {
+	c, err := OpenColumnar(path)
+	if err != nil {
+		return err
+	}
+	return c.Search(q, sink)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scanColumnar returns the users of a columnar file
+func scanColumnar(t *testing.T, c *Columnar) []UserType {
+	users := []UserType{}
+	err := c.Scan(allFields, func(i int, rec *userRecord) error {
+		users = append(users, rec.User())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func convertBytes(t *testing.T, input string) []byte {
+	out := new(bytes.Buffer)
+	if _, err := ConvertColumnar(strings.NewReader(input), out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestColumnarRoundTrip(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	lines = append(lines,
+		`{}`,
+		`null`,
+		`{"browsers":null,"name":"no browsers"}`,
+		`{"browsers":[],"name":"empty browsers"}`,
+		`{"browsers":["",null,"MSIE 8.0"],"email":"a\"b@c.d","name":"\u00e9\ud83d\ude00"}`,
+	)
+
+	c, err := ReadColumnar(convertBytes(t, strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Rows != len(lines) {
+		t.Fatalf("%d rows, expected %d", c.Rows, len(lines))
+	}
+	users := scanColumnar(t, c)
+	for i, line := range lines {
+		expected := UserType{}
+		if err := json.Unmarshal([]byte(line), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if len(expected.Browsers) == 0 {
+			// null and [] are stored the same
+			expected.Browsers = nil
+		}
+		if len(users[i].Browsers) == 0 {
+			users[i].Browsers = nil
+		}
+		if !reflect.DeepEqual(users[i], expected) {
+			t.Errorf("line %d:\ngot      %#v\nexpected %#v", i+1, users[i], expected)
+		}
+	}
+	// browsers are stored once
+	if len(c.Dictionary) > len(data)/100 {
+		t.Errorf("%d browsers in the dictionary", len(c.Dictionary))
+	}
+}
+
+func TestSearchColumnar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.col")
+	rows, err := ConvertColumnarFile(compressedCopies(t)[1], path)
+	if err != nil || rows != 1000 {
+		t.Fatalf("%d rows: %v", rows, err)
+	}
+	for _, query := range indexQueries {
+		q := MustCompileQuery(query)
+		expected := new(bytes.Buffer)
+		if err := SearchFile(filePath, q, NewTextSink(expected)); err != nil {
+			t.Fatal(err)
+		}
+		got := new(bytes.Buffer)
+		if err := SearchColumnar(path, q, NewTextSink(got)); err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", query, got, expected)
+		}
+	}
+
+	// reports need more columns than the output
+	args := []string{"-q", `browsers contains "MSIE"`, "-report", "country,browser.os," + HistogramReport, "-format", "json"}
+	expected := new(bytes.Buffer)
+	if err := run(args, expected); err != nil {
+		t.Fatal(err)
+	}
+	got := new(bytes.Buffer)
+	if err := run(append(args, "-in", path), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != expected.String() {
+		t.Errorf("reports not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+}
+
+func TestColumnarCorrupt(t *testing.T) {
+	data := convertBytes(t, `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}
+{"browsers":["Android 4.0"],"email":"d@e.f","name":"B"}`)
+	// every cut is noticed, and not with a panic
+	for n := 0; n < len(data); n++ {
+		if _, err := ReadColumnar(data[:n]); !errors.Is(err, ErrColumnarCorrupt) {
+			t.Errorf("%d bytes: expected ErrColumnarCorrupt, got %v", n, err)
+		}
+	}
+	if _, err := ReadColumnar(append(data, 0)); !errors.Is(err, ErrColumnarCorrupt) {
+		t.Errorf("trailing data: expected ErrColumnarCorrupt, got %v", err)
+	}
+
+	// a browser id out of the dictionary
+	c, err := ReadColumnar(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Dictionary = c.Dictionary[:1]
+	err = c.Scan(allFields, func(int, *userRecord) error { return nil })
+	if !errors.Is(err, ErrColumnarCorrupt) {
+		t.Errorf("expected ErrColumnarCorrupt, got %v", err)
+	}
+
+	_, err = ConvertColumnar(strings.NewReader("{}\n{"), ioutil.Discard)
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("expected error in line 2, got %v", err)
+	}
+}
+
+func TestRunCLIConvert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.col")
+	out := new(bytes.Buffer)
+	if err := run([]string{"convert", "-out", path}, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "converted 1000 users") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+	got := new(bytes.Buffer)
+	if err := run([]string{"-in", path, "-workers", "4"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+
+	for _, args := range [][]string{
+		{"convert"},
+		{"-in", path, "-index"},
+		{"-in", path, "-validate", ValidateSkip},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+// the file is read once, only decoding and the query are measured
+func BenchmarkColumnar(b *testing.B) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if _, err := ConvertColumnar(bytes.NewReader(data), bufio.NewWriter(out)); err != nil {
+		b.Fatal(err)
+	}
+	c, err := ReadColumnar(out.Bytes())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Search(defaultQuery, NewTextSink(ioutil.Discard))
+	}
+}
@@ -4,78 +4,84 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
-	"strings"
 )
 
-//easyjson:json
 type UserType struct {
 	Name     string   `json:"name"`
 	Email    string   `json:"email"`
 	Browsers []string `json:"browsers"`
+	Company  string   `json:"company"`
+	Country  string   `json:"country"`
+	Job      string   `json:"job"`
+	Phone    string   `json:"phone"`
 }
 
+var defaultQuery = MustCompileQuery(DefaultQuery)
+
 // вам надо написать более быструю оптимальную этой функции
 func FastSearch(out io.Writer) {
-	seenBrowsers := map[string]bool{}
-
-	file, err := os.Open(filePath)
-	if err != nil {
+	if err := FastSearchQuery(out, defaultQuery); err != nil {
 		panic(err)
 	}
+}
 
-	fmt.Fprintln(out, "found users:")
-	fscanner := bufio.NewScanner(file)
-	for i := 0; fscanner.Scan(); i++ {
-		user := UserType{}
-		// fmt.Printf("%v %v\n", err, line)
-		err := user.UnmarshalJSON(fscanner.Bytes())
-		// err := json.Unmarshal(fscanner.Bytes(), &user)
-		if err != nil {
-			panic(err)
-		}
+// FastSearchQuery prints users matching q in the FastSearch format
+func FastSearchQuery(out io.Writer, q *Query) error {
+	return SearchFile(filePath, q, NewTextSink(out))
+}
 
-		isAndroid := false
-		isMSIE := false
+// LineError is an error in a particular line of the input, Line starts from 1
+type LineError struct {
+	Line int
+	Err  error
+}
 
-		// browsers, ok := user["browsers"].([]interface{})
-		// if !ok {
-		if len(user.Browsers) == 0 {
-			// log.Println("cant cast browsers")
-			continue
-		}
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
 
-		// for _, browserRaw := range browsers {
-		// browser, ok := browserRaw.(string)
-		// if !ok {
-		// log.Println("cant cast browser to string")
-		// continue
-		// }
-		for _, browser := range user.Browsers {
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// fields printed for found users
+const outputFields = fieldSet(1<<fieldName | 1<<fieldEmail)
 
-			if strings.Contains(browser, "Android") {
-				isAndroid = true
-				seenBrowsers[browser] = true
+// searchLines runs q over json lines from r, line numbers start from first.
+// Lines are scanned for the fields used by q only, found users are scanned
+// once more for the output fields. Lines are checked by v first unless it is
+// nil. It returns the number of lines read.
+func searchLines(r io.Reader, first int, q *Query, v *Validator, output fieldSet, seenBrowsers map[string]bool, found func(i int, user *userRecord) error) (int, error) {
+	fscanner := bufio.NewScanner(r)
+	user := &userRecord{}
+	i := 0
+	for ; fscanner.Scan(); i++ {
+		if v != nil {
+			valid, err := v.check(first+i+1, fscanner.Bytes())
+			if err != nil {
+				return i, err
 			}
-			if strings.Contains(browser, "MSIE") {
-				isMSIE = true
-				seenBrowsers[browser] = true
+			if !valid {
+				continue
 			}
 		}
+		// fmt.Printf("%v %v\n", err, line)
+		err := scanUser(fscanner.Bytes(), user, q.fields)
+		// err := json.Unmarshal(fscanner.Bytes(), &user)
+		if err != nil {
+			return i, &LineError{first + i + 1, err}
+		}
 
-		if !(isAndroid && isMSIE) {
+		q.seenBrowsers(user, seenBrowsers)
+		if !q.root.match(user) {
 			continue
 		}
-
-		// log.Println("Android and MSIE user:", user["name"], user["email"])
-		// email := strings.ReplaceAll(user["email"].(string), "@", " [at] ")
-		//atPos := strings.IndexByte(user.Email, '@')
-
-		email := strings.ReplaceAll(user.Email, "@", " [at] ")
-		// fmt.Fprintf(out, "[%d] %s <%s>\n", i, user["name"], email)
-		//fmt.Fprintf(out, "[%d] %s <%s [at] %s>\n", i, user.Name, user.Email[:atPos], user.Email[atPos+1:])
-		fmt.Fprintf(out, "[%d] %s <%s>\n", i, user.Name, email)
+		if err := scanUser(fscanner.Bytes(), user, output); err != nil {
+			return i, &LineError{first + i + 1, err}
+		}
+		if err := found(first+i, user); err != nil {
+			return i, err
+		}
 	}
-
-	fmt.Fprintln(out, "\nTotal unique browsers", len(seenBrowsers))
+	return i, fscanner.Err()
 }
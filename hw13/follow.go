@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+var ErrFollowCompressed = errors.New("can't follow a compressed file")
+
+// FollowState is how far a Follower got, it may be saved to resume later
+type FollowState struct {
+	// the next byte and line to process
+	Offset int64 `json:"offset"`
+	Line   int   `json:"line"`
+	// the last line is processed but its '\n' is not written yet
+	PendingNewline bool `json:"pending_newline,omitempty"`
+	// hash of the first HeadSize bytes, to notice a different file on resume
+	HeadSize int64  `json:"head_size"`
+	HeadHash uint64 `json:"head_hash"`
+	// counted in "Total unique browsers"
+	Browsers []string `json:"browsers"`
+}
+
+// bytes of the file start remembered in FollowState
+const followHeadSize = 256
+
+// Follower tails a growing users file. Every Poll runs the query over the
+// lines appended since the previous one, passes new matches to the sink and
+// calls its Done with the unique browsers seen so far. Only complete lines
+// are processed: ending with '\n' or being a whole json value.
+//
+// When the file is truncated it is followed from the start. When it is
+// rotated (replaced by another file) the rest of the old file is processed
+// first. Line numbers start from 0 in the new file, while unique browsers
+// are counted over everything followed.
+//
+// A malformed line fails the Poll. The lines before it are done and State
+// is at it, so a resumed Follower fails on it again but does not pass the
+// earlier matches to the sink twice. A Validator that skips invalid lines
+// goes past it.
+type Follower struct {
+	Path  string
+	Query *Query
+	Sink  resultSink
+	State FollowState
+	// Validator checks the lines if not nil
+	Validator *Validator
+
+	file *os.File
+	seen map[string]bool
+}
+
+func NewFollower(path string, q *Query, sink resultSink, state FollowState) *Follower {
+	f := &Follower{Path: path, Query: q, Sink: sink, State: state, seen: map[string]bool{}}
+	for _, browser := range state.Browsers {
+		f.seen[browser] = true
+	}
+	f.State.Browsers = nil
+	return f
+}
+
+// Snapshot returns the current state with the seen browsers
+func (f *Follower) Snapshot() FollowState {
+	state := f.State
+	state.Browsers = make([]string, 0, len(f.seen))
+	for browser := range f.seen {
+		state.Browsers = append(state.Browsers, browser)
+	}
+	sort.Strings(state.Browsers)
+	return state
+}
+
+func (f *Follower) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *Follower) open() error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, len(zstdMagic))
+	n, _ := file.ReadAt(head, 0)
+	if detectCompression(head[:n]) != compressionNone {
+		file.Close()
+		return ErrFollowCompressed
+	}
+	f.file = file
+	return nil
+}
+
+// sameHead checks that the file starts as it did, a saved state may belong
+// to a file that is gone, and a truncated file may grow past the offset
+func (f *Follower) sameHead(size int64) bool {
+	if f.State.HeadSize == 0 {
+		return true
+	}
+	if size < f.State.HeadSize {
+		return false
+	}
+	hash, err := f.headHash(f.State.HeadSize)
+	return err == nil && hash == f.State.HeadHash
+}
+
+func (f *Follower) headHash(size int64) (uint64, error) {
+	head := make([]byte, size)
+	if _, err := f.file.ReadAt(head, 0); err != nil {
+		return 0, err
+	}
+	return hashBytes(head), nil
+}
+
+// restart follows the file from the start
+func (f *Follower) restart() {
+	f.State = FollowState{}
+}
+
+// Poll processes the lines appended since the last call and returns
+// their number
+func (f *Follower) Poll() (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	st, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// the path may be missing for a moment while the file is rotated
+	pathSt, err := os.Stat(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	rotated := err == nil && !os.SameFile(st, pathSt)
+
+	if st.Size() < f.State.Offset || !f.sameHead(st.Size()) {
+		f.restart()
+	}
+	lines, err := f.process(st.Size())
+	if err != nil {
+		return lines, err
+	}
+	if rotated {
+		f.Close()
+		f.restart()
+		if err := f.open(); err != nil {
+			return lines, err
+		}
+		if st, err = f.file.Stat(); err != nil {
+			return lines, err
+		}
+		n, err := f.process(st.Size())
+		lines += n
+		if err != nil {
+			return lines, err
+		}
+	}
+
+	if lines == 0 {
+		return 0, nil
+	}
+	return lines, f.Sink.Done(len(f.seen))
+}
+
+// process runs the query over complete lines in [State.Offset, size)
+func (f *Follower) process(size int64) (int, error) {
+	start := f.State.Offset
+	if f.State.PendingNewline {
+		// skip the line end of the already processed line
+		lineEnd := make([]byte, 2)
+		n, _ := f.file.ReadAt(lineEnd, start)
+		switch {
+		case n == 0:
+			return 0, nil
+		case bytes.HasPrefix(lineEnd[:n], []byte("\r\n")):
+			start += 2
+		case lineEnd[0] == '\n':
+			start++
+		case lineEnd[0] == '\r' && n == 1:
+			// wait for the '\n'
+			return 0, nil
+		}
+	}
+
+	end, err := completeLinesEnd(f.file, start, size)
+	if err != nil {
+		return 0, err
+	}
+	pending := false
+	if end < size {
+		// the last line is complete when it is a whole json value
+		tail := make([]byte, size-end)
+		if _, err := f.file.ReadAt(tail, end); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if scanUser(tail, &userRecord{}, 0) == nil {
+			end, pending = size, true
+		}
+	}
+	if end <= start {
+		// nothing new, but the line end of the pending line may be consumed
+		if start > f.State.Offset {
+			f.State.Offset, f.State.PendingNewline = start, false
+		}
+		return 0, nil
+	}
+
+	section := io.NewSectionReader(f.file, start, end-start)
+	lines, searchErr := searchLines(section, f.State.Line, f.Query, f.Validator, sinkFields(f.Sink), f.seen, f.Sink.Found)
+	if searchErr != nil {
+		// the matches before the failing line are passed to the sink already
+		if end, err = linesEnd(f.file, start, lines); err != nil {
+			return lines, searchErr
+		}
+		pending = false
+	}
+
+	f.State.Offset = end
+	f.State.Line += lines
+	f.State.PendingNewline = pending
+	if f.State.HeadSize < followHeadSize && f.State.HeadSize < end {
+		f.State.HeadSize = end
+		if f.State.HeadSize > followHeadSize {
+			f.State.HeadSize = followHeadSize
+		}
+		if f.State.HeadHash, err = f.headHash(f.State.HeadSize); err != nil {
+			return lines, err
+		}
+	}
+	return lines, searchErr
+}
+
+// linesEnd returns the offset after n lines from start, they all end
+// with '\n'
+func linesEnd(r io.ReaderAt, start int64, n int) (int64, error) {
+	buf := make([]byte, 64*1024)
+	offset := start
+	for n > 0 {
+		m, err := r.ReadAt(buf, offset)
+		chunk := buf[:m]
+		for ; n > 0; n-- {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			chunk = chunk[i+1:]
+		}
+		if n == 0 {
+			return offset + int64(m-len(chunk)), nil
+		}
+		offset += int64(m)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return offset, nil
+}
+
+// completeLinesEnd returns the offset after the last '\n' in [start, size),
+// start if there is none
+func completeLinesEnd(r io.ReaderAt, start, size int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for end := size; end > start; {
+		from := end - int64(len(buf))
+		if from < start {
+			from = start
+		}
+		chunk := buf[:end-from]
+		if _, err := r.ReadAt(chunk, from); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return from + int64(i) + 1, nil
+		}
+		end = from
+	}
+	return start, nil
+}
+
+// Run polls the file every interval until ctx is done. afterBatch, if not
+// nil, is called after every poll that found new lines, it is the place to
+// flush the output and save the state. A missing file is waited for.
+func (f *Follower) Run(ctx context.Context, interval time.Duration, afterBatch func(FollowState) error) error {
+	defer f.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		lines, err := f.Poll()
+		if err != nil && !os.IsNotExist(err) {
+			// the lines before a malformed one are done, a resumed
+			// follower starts at it
+			if afterBatch != nil {
+				afterBatch(f.Snapshot())
+			}
+			return err
+		}
+		if lines > 0 && afterBatch != nil {
+			if err := afterBatch(f.Snapshot()); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// followSink records found lines and the totals, it is safe for Run
+type followSink struct {
+	mu     sync.Mutex
+	found  []string
+	totals []int
+}
+
+func (s *followSink) Found(i int, user *userRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.found = append(s.found, fmt.Sprintf("[%d] %s", i, user.name))
+	return nil
+}
+
+func (s *followSink) Done(uniqueBrowsers int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totals = append(s.totals, uniqueBrowsers)
+	return nil
+}
+
+func (s *followSink) result() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := -1
+	if len(s.totals) > 0 {
+		total = s.totals[len(s.totals)-1]
+	}
+	return strings.Join(s.found, "\n"), total
+}
+
+// searchResult is what a follower must have found in data
+func searchResult(t *testing.T, data string) (string, int) {
+	sink := &followSink{}
+	if err := Search(strings.NewReader(data), defaultQuery, sink); err != nil {
+		t.Fatal(err)
+	}
+	return sink.result()
+}
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func poll(t *testing.T, f *Follower) int {
+	lines, err := f.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestFollowAppend(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := string(data)
+	path := filepath.Join(t.TempDir(), "users.txt")
+
+	sink := &followSink{}
+	f := NewFollower(path, defaultQuery, sink, FollowState{})
+	defer f.Close()
+	if _, err := f.Poll(); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file error, got %v", err)
+	}
+
+	// appended in pieces cut in the middle of lines, the data has no final '\n'
+	written := 0
+	for _, cut := range []int{len(all) / 3, len(all) / 3 * 2, len(all) - 10, len(all)} {
+		appendFile(t, path, all[written:cut])
+		written = cut
+		poll(t, f)
+
+		complete := all[:strings.LastIndexByte(all[:cut], '\n')+1]
+		if cut == len(all) {
+			complete = all
+		}
+		expected, _ := searchResult(t, complete)
+		if got, _ := sink.result(); got != expected {
+			t.Fatalf("after %d bytes: results not match\nGot:\n%v\nExpected:\n%v", cut, got, expected)
+		}
+	}
+	expected, total := searchResult(t, all)
+	if got, gotTotal := sink.result(); got != expected || gotTotal != total {
+		t.Errorf("total %d, expected %d", gotTotal, total)
+	}
+	if f.State.Line != 1000 || f.State.Offset != int64(len(all)) || !f.State.PendingNewline {
+		t.Errorf("unexpected state %+v", f.State)
+	}
+	if poll(t, f) != 0 || len(sink.totals) != 4 {
+		t.Errorf("nothing new is reported: %v", sink.totals)
+	}
+
+	// the last line was complete without '\n'
+	user := `{"browsers":["Android 9","MSIE 11"],"email":"new@user.org","name":"New User"}`
+	appendFile(t, path, "\n")
+	if poll(t, f) != 0 {
+		t.Errorf("a line end is counted as a line")
+	}
+	appendFile(t, path, user+"\n")
+	if poll(t, f) != 1 {
+		t.Errorf("the new line is not processed")
+	}
+	expected, total = searchResult(t, all+"\n"+user+"\n")
+	if got, gotTotal := sink.result(); got != expected || gotTotal != total {
+		t.Errorf("results not match\nGot:\n%v %d\nExpected:\n%v %d", got, gotTotal, expected, total)
+	}
+}
+
+func TestFollowTruncateAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.txt")
+	lines := []string{
+		`{"browsers":["MSIE 8.0","Android 4.0"],"name":"A"}`,
+		`{"browsers":["Chrome"],"name":"B"}`,
+		`{"browsers":["Android 2.0","MSIE 6.0"],"name":"C"}`,
+		`{"browsers":["Android 3.0","MSIE 7.0"],"name":"D"}`,
+	}
+	appendFile(t, path, lines[0]+"\n"+lines[1]+"\n")
+
+	sink := &followSink{}
+	f := NewFollower(path, defaultQuery, sink, FollowState{})
+	defer f.Close()
+	poll(t, f)
+
+	// truncated and written anew, even longer than before
+	if err := ioutil.WriteFile(path, []byte(lines[2]+"\n"+lines[1]+"\n"+lines[3]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, f)
+	if got, total := sink.result(); got != "[0] A\n[0] C\n[2] D" || total != 6 {
+		t.Errorf("after truncation: %q %d", got, total)
+	}
+
+	// the rest of the rotated file comes first
+	appendFile(t, path, lines[0]+"\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, f)
+	appendFile(t, path, lines[1]+"\n"+lines[3]+"\n")
+	poll(t, f)
+	if got, _ := sink.result(); got != "[0] A\n[0] C\n[2] D\n[3] A\n[1] D" {
+		t.Errorf("after rotation: %q", got)
+	}
+}
+
+func TestFollowResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	first := `{"browsers":["MSIE 8.0","Android 4.0"],"name":"A"}` + "\n"
+	second := `{"browsers":["Android 2.0","MSIE 6.0"],"name":"B"}` + "\n"
+	appendFile(t, path, first)
+
+	f := NewFollower(path, defaultQuery, &followSink{}, FollowState{})
+	poll(t, f)
+	f.Close()
+	data, _ := json.Marshal(f.Snapshot())
+	state := FollowState{}
+	json.Unmarshal(data, &state)
+
+	appendFile(t, path, second)
+	sink := &followSink{}
+	f = NewFollower(path, defaultQuery, sink, state)
+	poll(t, f)
+	f.Close()
+	if got, total := sink.result(); got != "[1] B" || total != 4 {
+		t.Errorf("after resume: %q %d", got, total)
+	}
+
+	// the state of another file
+	ioutil.WriteFile(path, []byte(second+first+first), 0644)
+	sink = &followSink{}
+	f = NewFollower(path, defaultQuery, sink, state)
+	defer f.Close()
+	poll(t, f)
+	if got, _ := sink.result(); got != "[0] B\n[1] A\n[2] A" {
+		t.Errorf("another file: %q", got)
+	}
+}
+
+func TestFollowErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	appendFile(t, path, `{"browsers":["MSIE 8.0","Android 4.0"],"name":"A"}`+"\n")
+	f := NewFollower(path, defaultQuery, &followSink{}, FollowState{})
+	defer f.Close()
+	poll(t, f)
+	appendFile(t, path, `{"browsers":["MSIE 8.0",`+"\n")
+	_, err := f.Poll()
+	lineErr := &LineError{}
+	if !asLineError(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("expected error in line 2, got %v", err)
+	}
+	// the state is before the bad line, only skipping goes past it
+	if f.State.Line != 1 {
+		t.Errorf("expected the state at line 1, got %d", f.State.Line)
+	}
+	appendFile(t, path, `{"browsers":["Chrome"],"email":"c@d.e","name":"C"}`+"\n")
+	again := NewFollower(path, defaultQuery, &followSink{}, f.Snapshot())
+	if _, err := again.Poll(); err == nil {
+		t.Errorf("expected the resumed follower to fail again")
+	}
+	again.Close()
+	v, err := NewValidator(UserSchema, ValidateSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Report = func(Violation) error { return nil }
+	sink := &followSink{}
+	resumed := NewFollower(path, defaultQuery, sink, f.Snapshot())
+	resumed.Validator = v
+	defer resumed.Close()
+	if _, err := resumed.Poll(); err != nil || resumed.State.Line != 3 {
+		t.Errorf("expected the skipping follower at line 3, got %d %v", resumed.State.Line, err)
+	}
+
+	gz := NewFollower(compressedCopies(t)[0], defaultQuery, &followSink{}, FollowState{})
+	if _, err := gz.Poll(); err != ErrFollowCompressed {
+		t.Errorf("expected ErrFollowCompressed, got %v", err)
+	}
+}
+
+// a follower resumed after a malformed line does not find the users
+// before it again
+func TestFollowResumeAfterError(t *testing.T) {
+	user := func(name string) string {
+		return `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"` + name + `"}` + "\n"
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.txt")
+	statePath := filepath.Join(dir, "users.state")
+	appendFile(t, path, user("A"))
+	sink := &followSink{}
+	f := NewFollower(path, defaultQuery, sink, FollowState{})
+	defer f.Close()
+	poll(t, f)
+	appendFile(t, path, user("B")+`{"browsers":["MSIE 8.0",`+"\n"+user("C"))
+	if _, err := f.Poll(); err == nil {
+		t.Fatal("expected an error for line 3")
+	}
+	if got, _ := sink.result(); got != "[0] A\n[1] B" {
+		t.Errorf("expected the users before the bad line, got %q", got)
+	}
+	if f.State.Line != 2 || f.State.Offset != int64(2*len(user("A"))) || f.State.PendingNewline {
+		t.Errorf("expected the state at the bad line, got %+v", f.State)
+	}
+
+	// the CLI saves the state at the bad line too
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	followContext = func() (context.Context, context.CancelFunc) { return ctx, cancel }
+	out := &lockedBuffer{}
+	if err := run([]string{"-follow", "-in", path, "-state", statePath, "-format", "json"}, out); err == nil {
+		t.Errorf("expected an error for line 3")
+	}
+	if strings.Count(out.String(), `"name":"B"`) != 1 {
+		t.Errorf("expected B once, got %q", out.String())
+	}
+
+	v, err := NewValidator(UserSchema, ValidateSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Report = func(Violation) error { return nil }
+	for _, state := range []FollowState{f.Snapshot(), loadFollowState(t, statePath)} {
+		sink := &followSink{}
+		resumed := NewFollower(path, defaultQuery, sink, state)
+		resumed.Validator = v
+		poll(t, resumed)
+		resumed.Close()
+		if got, _ := sink.result(); got != "[3] C" {
+			t.Errorf("expected only the user after the bad line, got %q", got)
+		}
+	}
+}
+
+func TestLinesEnd(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	data := "a\n" + long + "\nb\nc"
+	for _, c := range []struct {
+		start    int64
+		n        int
+		expected int64
+	}{
+		{0, 0, 0},
+		{0, 1, 2},
+		{0, 2, int64(len(long)) + 3},
+		{2, 2, int64(len(long)) + 5},
+	} {
+		got, err := linesEnd(strings.NewReader(data), c.start, c.n)
+		if err != nil || got != c.expected {
+			t.Errorf("%d lines from %d: expected %d, got %d %v", c.n, c.start, c.expected, got, err)
+		}
+	}
+	if _, err := linesEnd(strings.NewReader(data), 0, 4); err == nil {
+		t.Errorf("expected an error past the last line end")
+	}
+}
+
+func loadFollowState(t *testing.T, path string) FollowState {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := FollowState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// the violations of lines appended after the first poll have the file line numbers
+func TestFollowValidate(t *testing.T) {
+	valid := `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}` + "\n"
+	invalid := `{"browsers":["MSIE 8.0"],"name":"B"}` + "\n"
+	for _, policy := range []string{ValidateSkip, ValidateFail} {
+		path := filepath.Join(t.TempDir(), "users.txt")
+		appendFile(t, path, valid+valid)
+		v, err := NewValidator(UserSchema, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reported []string
+		v.Report = func(violation Violation) error {
+			reported = append(reported, violation.String())
+			return nil
+		}
+		sink := &followSink{}
+		f := NewFollower(path, defaultQuery, sink, FollowState{})
+		f.Validator = v
+		poll(t, f)
+		appendFile(t, path, valid+invalid+valid)
+		_, err = f.Poll()
+		f.Close()
+
+		if expected := []string{"line 4: email: is missing"}; !reflect.DeepEqual(reported, expected) {
+			t.Errorf("%s: reported %q, expected %q", policy, reported, expected)
+		}
+		if policy == ValidateSkip {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := sink.result(); got != "[0] A\n[1] A\n[2] A\n[4] A" {
+				t.Errorf("%s: found %q", policy, got)
+			}
+			continue
+		}
+		lineErr := &LineError{}
+		if !asLineError(err, &lineErr) || lineErr.Line != 4 {
+			t.Fatalf("%s: expected error in line 4, got %v", policy, err)
+		}
+		schemaErr, ok := lineErr.Err.(*SchemaError)
+		if !ok || len(schemaErr.Violations) != 1 || schemaErr.Violations[0].Line != 4 {
+			t.Errorf("%s: unexpected violations %v", policy, lineErr.Err)
+		}
+	}
+}
+
+func asLineError(err error, target **LineError) bool {
+	lineErr, ok := err.(*LineError)
+	if ok {
+		*target = lineErr
+	}
+	return ok
+}
+
+func TestFollowRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.txt")
+	statePath := filepath.Join(dir, "users.state")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	followContext = func() (context.Context, context.CancelFunc) { return ctx, cancel }
+
+	out := &lockedBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- run([]string{"-follow", "-in", path, "-interval", "5ms", "-state", statePath, "-format", "json"}, out)
+	}()
+
+	// the file appears later
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}`+"\n")
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(out.String(), `"unique_browsers":2`); {
+		if time.Now().After(deadline) {
+			t.Fatalf("no results in time: %q", out.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":0,"name":"A","email":"a@b.c"}` + "\n" + `{"unique_browsers":2}` + "\n"
+	if out.String() != expected {
+		t.Errorf("got %q, expected %q", out.String(), expected)
+	}
+
+	data, err := ioutil.ReadFile(statePath)
+	state := FollowState{}
+	if err != nil || json.Unmarshal(data, &state) != nil || state.Line != 1 || len(state.Browsers) != 2 {
+		t.Errorf("bad saved state %s: %v", data, err)
+	}
+
+	if err := run([]string{"-follow", "-in", "-"}, ioutil.Discard); err == nil {
+		t.Errorf("expected error for following stdin")
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -1,5 +1,5 @@
 module hw3
 
-go 1.16
+go 1.18
 
-require github.com/mailru/easyjson v0.7.7 // indirect
+require github.com/klauspost/compress v1.15.15
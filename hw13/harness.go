@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchImpl is an implementation of the default search over a users file,
+// all of them must print exactly what SlowSearch prints.
+type SearchImpl struct {
+	Name string
+	Run  func(out io.Writer, path string) error
+}
+
+// benchWorkers is the parallel workers of the harness. The memory of a
+// parallel search grows with its chunks, so with runtime.NumCPU() workers
+// a baseline would only hold on machines with as many CPUs.
+const benchWorkers = 4
+
+// SearchImpls are compared by the benchmark harness, the first one is the
+// reference. New implementations go to the end.
+var SearchImpls = []SearchImpl{
+	{"slow", slowSearchFile},
+	{"fast", func(out io.Writer, path string) error {
+		return SearchFile(path, defaultQuery, NewTextSink(out))
+	}},
+	{"parallel", func(out io.Writer, path string) error {
+		return ParallelSearchFile(path, defaultQuery, benchWorkers, NewTextSink(out))
+	}},
+	{"indexed", func(out io.Writer, path string) error {
+		return SearchIndexed(path, defaultQuery, NewTextSink(out))
+	}},
+	{"columnar", func(out io.Writer, path string) error {
+		colPath, err := columnarCopy(path)
+		if err != nil {
+			return err
+		}
+		return SearchColumnar(colPath, defaultQuery, NewTextSink(out))
+	}},
+}
+
+// columnarCopy converts the file at path once, like SearchIndexed builds
+// its index once, so that only searches are measured
+func columnarCopy(path string) (string, error) {
+	colPath := path + ".col"
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if colSt, err := os.Stat(colPath); err == nil && !colSt.ModTime().Before(st.ModTime()) {
+		return colPath, nil
+	}
+	_, err = ConvertColumnarFile(path, colPath)
+	return colPath, err
+}
+
+func slowSearchFile(out io.Writer, path string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("slow search: %v", r)
+		}
+	}()
+	slowSearch(out, path)
+	return nil
+}
+
+var (
+	firstNames = []string{"Sharon", "Jonathan", "Susan", "Anthony", "Maria", "Walter", "Irene", "Kevin", "Diana", "Roy"}
+	lastNames  = []string{"Crawford", "Morris", "Ellis", "Rivera", "Hughes", "Gordon", "Fuller", "Bailey", "Ward", "Ortiz"}
+	companies  = []string{"Flashpoint", "Muxo", "Jatri", "Topiczoom", "Livetube", "Realcube", "Photojam", "Skiba", "Yodel", "Quatz"}
+	countries  = []string{"Kenya", "Fiji", "Greenland", "Dominican Republic", "Mauritania", "Antarctica", "Peru", "Norway"}
+	jobs       = []string{"Programmer Analyst #{N}", "Accountant #{N}", "Nurse", "Geologist", "Web Designer #{N}"}
+	domains    = []string{"edu", "com", "org", "info", "net"}
+)
+
+// syntheticBrowser returns one of a few hundred user agents,
+// roughly a third of them are Android and a third are MSIE
+func syntheticBrowser(rnd *rand.Rand) string {
+	switch rnd.Intn(6) {
+	case 0, 1:
+		return fmt.Sprintf("Mozilla/5.0 (Linux; U; Android %d.%d; en-us; Build/%c%d) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1",
+			1+rnd.Intn(8), rnd.Intn(4), 'A'+rnd.Intn(6), rnd.Intn(5))
+	case 2, 3:
+		return fmt.Sprintf("Mozilla/4.0 (compatible; MSIE %d.0; Windows NT %d.%d; Trident/%d.0)",
+			6+rnd.Intn(5), 5+rnd.Intn(2), rnd.Intn(4), 4+rnd.Intn(3))
+	case 4:
+		return fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%d.0.%d.0 Safari/537.36",
+			30+rnd.Intn(30), 1000+rnd.Intn(20))
+	}
+	return fmt.Sprintf("Mozilla/5.0 (Windows NT 6.1; rv:%d.0) Gecko/20100101 Firefox/%d.0", 10+rnd.Intn(40), 10+rnd.Intn(40))
+}
+
+func pick(rnd *rand.Rand, list []string) string {
+	return list[rnd.Intn(len(list))]
+}
+
+// GenerateUsers writes n random users in the users.txt format, the same
+// seed gives the same users. Like data/users.txt the last line has no '\n',
+// SlowSearch can't handle an empty line.
+func GenerateUsers(w io.Writer, n int, seed int64) error {
+	rnd := rand.New(rand.NewSource(seed))
+	bw := bufio.NewWriter(w)
+	for i := 0; i < n; i++ {
+		first, last, company := pick(rnd, firstNames), pick(rnd, lastNames), pick(rnd, companies)
+		user := UserType{
+			Name:     first + " " + last,
+			Email:    fmt.Sprintf("%s%s%d@%s.%s", first, last, rnd.Intn(100), company, pick(rnd, domains)),
+			Browsers: make([]string, 1+rnd.Intn(5)),
+			Company:  company,
+			Country:  pick(rnd, countries),
+			Job:      pick(rnd, jobs),
+			Phone:    fmt.Sprintf("%03d-%02d-%02d", rnd.Intn(1000), rnd.Intn(100), rnd.Intn(100)),
+		}
+		for j := range user.Browsers {
+			user.Browsers[j] = syntheticBrowser(rnd)
+		}
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			bw.WriteByte('\n')
+		}
+		bw.Write(data)
+	}
+	return bw.Flush()
+}
+
+// BenchResult is a benchmark result of an implementation on a dataset
+type BenchResult struct {
+	NsPerOp     int64 `json:"ns_per_op"`
+	BytesPerOp  int64 `json:"bytes_per_op"`
+	AllocsPerOp int64 `json:"allocs_per_op"`
+}
+
+// Baseline is the stored BenchResults, keyed by "implementation/size".
+// ns/op depends on the machine, so only its ratio to the ns/op of
+// ReferenceImpl on the same dataset is compared, and the baseline notes
+// where it was taken.
+type Baseline struct {
+	GOOS      string                 `json:"goos"`
+	GOARCH    string                 `json:"goarch"`
+	GoVersion string                 `json:"go_version"`
+	CPUs      int                    `json:"cpus"`
+	Results   map[string]BenchResult `json:"results"`
+}
+
+func NewBaseline(results map[string]BenchResult) *Baseline {
+	return &Baseline{
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		GoVersion: runtime.Version(),
+		CPUs:      runtime.NumCPU(),
+		Results:   results,
+	}
+}
+
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Baseline{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("baseline %s: %w", path, err)
+	}
+	return b, nil
+}
+
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		return err
+	}
+	// an interrupted -update leaves the old baseline
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+func (b *Baseline) result(name string) (BenchResult, bool) {
+	if b == nil {
+		return BenchResult{}, false
+	}
+	res, ok := b.Results[name]
+	return res, ok
+}
+
+// ReferenceImpl is the implementation the times are relative to
+const ReferenceImpl = "slow"
+
+// relativeTime returns ns/op of the result name relative to the one of
+// ReferenceImpl on the same dataset, 0 if there is no such result
+func relativeTime(results map[string]BenchResult, name string) float64 {
+	size := name[strings.LastIndexByte(name, '/')+1:]
+	ref, ok := results[ReferenceImpl+"/"+size]
+	if !ok || ref.NsPerOp == 0 {
+		return 0
+	}
+	return float64(results[name].NsPerOp) / float64(ref.NsPerOp)
+}
+
+// Thresholds are the allowed relative growth of the metrics, 0.2 is +20%
+type Thresholds struct {
+	NsPerOp     float64
+	BytesPerOp  float64
+	AllocsPerOp float64
+}
+
+// DefaultThresholds are loose for time, which is noisy, and tight for memory
+var DefaultThresholds = Thresholds{NsPerOp: 0.3, BytesPerOp: 0.1, AllocsPerOp: 0.1}
+
+// Regression is a metric that grew more than its threshold
+type Regression struct {
+	Name     string
+	Metric   string
+	Baseline float64
+	Got      float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %s %.4g -> %.4g (%+.1f%%)", r.Name, r.Metric, r.Baseline, r.Got,
+		100*(r.Got/r.Baseline-1))
+}
+
+// Compare returns regressions of results against the baseline, results
+// missing in the baseline are not compared. ns/op is compared relative to
+// ReferenceImpl, so only if both have its result for the dataset.
+func (b *Baseline) Compare(results map[string]BenchResult, th Thresholds) []Regression {
+	regressions := []Regression{}
+	nsMetric := "ns/op of " + ReferenceImpl
+	for _, name := range sortedNames(results) {
+		base, ok := b.Results[name]
+		if !ok {
+			continue
+		}
+		got := results[name]
+		for _, m := range []struct {
+			metric    string
+			base, got float64
+			threshold float64
+		}{
+			{nsMetric, relativeTime(b.Results, name), relativeTime(results, name), th.NsPerOp},
+			{"B/op", float64(base.BytesPerOp), float64(got.BytesPerOp), th.BytesPerOp},
+			{"allocs/op", float64(base.AllocsPerOp), float64(got.AllocsPerOp), th.AllocsPerOp},
+		} {
+			if m.metric == nsMetric && (m.base == 0 || m.got == 0) {
+				continue
+			}
+			if m.got > m.base*(1+m.threshold) {
+				regressions = append(regressions, Regression{name, m.metric, m.base, m.got})
+			}
+		}
+	}
+	return regressions
+}
+
+func sortedNames(results map[string]BenchResult) []string {
+	names := []string{}
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HarnessConfig describes a harness run
+type HarnessConfig struct {
+	Dir   string // for the generated datasets
+	Sizes []int  // users in each dataset
+	Seed  int64
+	Impls []SearchImpl
+	// BenchTime is how long an implementation runs at least on a dataset,
+	// DefaultBenchTime if it is 0
+	BenchTime time.Duration
+}
+
+// datasetVersion is in the names of the generated datasets, bump it when
+// GenerateUsers changes so that the datasets of the old one are not reused
+const datasetVersion = 1
+
+// DatasetPath is where the harness keeps the dataset of size users
+func DatasetPath(dir string, size int, seed int64) string {
+	return filepath.Join(dir, fmt.Sprintf("users-v%d-%d-%d.txt", datasetVersion, size, seed))
+}
+
+// MismatchError is returned when an implementation prints something
+// other than the reference one
+type MismatchError struct {
+	Impl, Reference string
+	Path            string
+	Got, Expected   string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s: %s output differs from %s", e.Path, e.Impl, e.Reference)
+}
+
+// RunHarness generates the datasets, checks that all the implementations
+// print the same and benchmarks them. Results are keyed by "impl/size".
+func RunHarness(cfg HarnessConfig) (map[string]BenchResult, error) {
+	if len(cfg.Impls) == 0 {
+		return nil, fmt.Errorf("no implementations to run")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	benchTime := cfg.BenchTime
+	if benchTime <= 0 {
+		benchTime = DefaultBenchTime
+	}
+	results := map[string]BenchResult{}
+	for _, size := range cfg.Sizes {
+		path := DatasetPath(cfg.Dir, size, cfg.Seed)
+		if err := generateDataset(path, size, cfg.Seed); err != nil {
+			return nil, err
+		}
+		if err := VerifyImpls(cfg.Impls, path); err != nil {
+			return nil, err
+		}
+		for _, impl := range cfg.Impls {
+			res, err := benchmarkImpl(impl, path, benchTime)
+			if err != nil {
+				return nil, err
+			}
+			results[fmt.Sprintf("%s/%d", impl.Name, size)] = res
+		}
+	}
+	return results, nil
+}
+
+// generateDataset writes the dataset unless it is already there, path is
+// from DatasetPath for the same size and seed
+func generateDataset(path string, size int, seed int64) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return GenerateUsers(w, size, seed)
+	})
+}
+
+// VerifyImpls runs impls over the file at path and compares their output
+// with the output of the first one
+func VerifyImpls(impls []SearchImpl, path string) error {
+	expected := new(bytes.Buffer)
+	if err := impls[0].Run(expected, path); err != nil {
+		return fmt.Errorf("%s: %w", impls[0].Name, err)
+	}
+	for _, impl := range impls[1:] {
+		got := new(bytes.Buffer)
+		if err := impl.Run(got, path); err != nil {
+			return fmt.Errorf("%s: %w", impl.Name, err)
+		}
+		if got.String() != expected.String() {
+			return &MismatchError{impl.Name, impls[0].Name, path, got.String(), expected.String()}
+		}
+	}
+	return nil
+}
+
+// DefaultBenchTime is the default -benchtime of go test
+const DefaultBenchTime = time.Second
+
+// benchmarkImpl measures impl like testing.Benchmark, with more and more
+// runs until they take benchTime. The testing package would bring its
+// flags into the binary.
+func benchmarkImpl(impl SearchImpl, path string, benchTime time.Duration) (BenchResult, error) {
+	for n := 1; ; {
+		res, elapsed, err := timeRuns(impl, path, n)
+		if err != nil || elapsed >= benchTime || n >= 1e9 {
+			return res, err
+		}
+		// aim a fifth past benchTime, growing at most 100 times
+		next := int(1.2 * float64(n) * float64(benchTime) / float64(elapsed+1))
+		if next > 100*n {
+			next = 100 * n
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+}
+
+// timeRuns runs impl n times and returns the average of a run
+func timeRuns(impl SearchImpl, path string, n int) (BenchResult, time.Duration, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := impl.Run(ioutil.Discard, path); err != nil {
+			return BenchResult{}, 0, fmt.Errorf("%s: %w", impl.Name, err)
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return BenchResult{
+		NsPerOp:     elapsed.Nanoseconds() / int64(n),
+		BytesPerOp:  int64(after.TotalAlloc-before.TotalAlloc) / int64(n),
+		AllocsPerOp: int64(after.Mallocs-before.Mallocs) / int64(n),
+	}, elapsed, nil
+}
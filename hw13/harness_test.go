@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var regress = flag.Bool("regress", false, "compare benchmarks with testdata/bench_baseline.json")
+
+func TestGenerateUsers(t *testing.T) {
+	data := new(bytes.Buffer)
+	if err := GenerateUsers(data, 100, 1); err != nil {
+		t.Fatal(err)
+	}
+	again := new(bytes.Buffer)
+	GenerateUsers(again, 100, 1)
+	if data.String() != again.String() {
+		t.Errorf("the same seed gives different users")
+	}
+	other := new(bytes.Buffer)
+	GenerateUsers(other, 100, 2)
+	if data.String() == other.String() {
+		t.Errorf("different seeds give the same users")
+	}
+
+	if bytes.HasSuffix(data.Bytes(), []byte("\n")) {
+		t.Errorf("the last line ends with a newline")
+	}
+	lines := 0
+	scanner := bufio.NewScanner(data)
+	for ; scanner.Scan(); lines++ {
+		user := UserType{}
+		if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+			t.Fatalf("line %d: %s", lines+1, err)
+		}
+		if user.Name == "" || !strings.Contains(user.Email, "@") || len(user.Browsers) == 0 {
+			t.Errorf("line %d: incomplete user %+v", lines+1, user)
+		}
+	}
+	if lines != 100 {
+		t.Errorf("%d users generated", lines)
+	}
+}
+
+func TestVerifyImpls(t *testing.T) {
+	dir := t.TempDir()
+	path := DatasetPath(dir, 500, 1)
+	if err := generateDataset(path, 500, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyImpls(SearchImpls, path); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	SearchImpls[0].Run(out, path)
+	if strings.Count(out.String(), "\n") < 10 {
+		t.Errorf("too few users found in the dataset\n%s", out)
+	}
+
+	broken := SearchImpl{"broken", func(out io.Writer, path string) error {
+		return SearchFile(path, MustCompileQuery(`browsers contains "Android"`), NewTextSink(out))
+	}}
+	mismatch := &MismatchError{}
+	if err := VerifyImpls([]SearchImpl{SearchImpls[0], broken}, path); !errors.As(err, &mismatch) || mismatch.Impl != "broken" {
+		t.Errorf("expected mismatch of the broken implementation, got %v", err)
+	}
+}
+
+func TestBaselineCompare(t *testing.T) {
+	baseline := &Baseline{Results: map[string]BenchResult{
+		"fast/1000":     {NsPerOp: 1000, BytesPerOp: 1000, AllocsPerOp: 100},
+		"parallel/1000": {NsPerOp: 1000},
+		"slow/1000":     {NsPerOp: 10000, BytesPerOp: 1000, AllocsPerOp: 0},
+		"fast/2000":     {NsPerOp: 1000},
+	}}
+	// a machine twice as fast, the times are compared relative to slow
+	results := map[string]BenchResult{
+		"fast/1000":     {NsPerOp: 600, BytesPerOp: 1200, AllocsPerOp: 90},
+		"parallel/1000": {NsPerOp: 800},
+		"slow/1000":     {NsPerOp: 5000, BytesPerOp: 1000, AllocsPerOp: 1},
+		"new/1000":      {NsPerOp: 1e9, BytesPerOp: 1e9, AllocsPerOp: 1e9},
+		// no slow result to compare the time with
+		"fast/2000": {NsPerOp: 1e9},
+	}
+	got := []string{}
+	for _, r := range baseline.Compare(results, DefaultThresholds) {
+		got = append(got, r.Name+" "+r.Metric)
+	}
+	expected := []string{"fast/1000 B/op", "parallel/1000 ns/op of slow", "slow/1000 allocs/op"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got regressions %v, expected %v", got, expected)
+	}
+}
+
+func TestBaselineCoversImpls(t *testing.T) {
+	baseline, err := LoadBaseline("testdata/bench_baseline.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default -sizes of go run . bench
+	for _, impl := range SearchImpls {
+		for _, size := range []int{1000, 10000} {
+			if _, ok := baseline.Results[fmt.Sprintf("%s/%d", impl.Name, size)]; !ok {
+				t.Errorf("no baseline for %s/%d, add it with go run . bench -update", impl.Name, size)
+			}
+		}
+	}
+}
+
+func TestBenchmarkImplError(t *testing.T) {
+	failing := SearchImpl{"failing", func(out io.Writer, path string) error {
+		return errors.New("no file")
+	}}
+	if _, err := benchmarkImpl(failing, "users.txt", time.Millisecond); err == nil || err.Error() != "failing: no file" {
+		t.Errorf("expected the error of the run, got %v", err)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmarks are slow")
+	}
+	dir := t.TempDir()
+	baselinePath := filepath.Join(dir, "baseline.json")
+	args := []string{"-sizes", "200", "-impl", "fast", "-dir", dir, "-baseline", baselinePath, "-benchtime", "5ms"}
+	if err := run(append([]string{"bench", "-update"}, args...), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := LoadBaseline(baselinePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, ok := baseline.Results["fast/200"]; !ok || res.NsPerOp == 0 || res.AllocsPerOp == 0 {
+		t.Fatalf("bad baseline %+v", baseline)
+	}
+
+	// the allocations are stable, the time is not
+	if err := run(append([]string{"bench", "-max-ns", "100"}, args...), ioutil.Discard); err != nil {
+		t.Errorf("unexpected regression: %s", err)
+	}
+
+	baseline.Results["fast/200"] = BenchResult{NsPerOp: 1, BytesPerOp: 1, AllocsPerOp: 1}
+	baseline.Save(baselinePath)
+	err = run(append([]string{"bench"}, args...), ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "fast/200: allocs/op") {
+		t.Errorf("expected a regression, got %v", err)
+	}
+
+	for _, bad := range [][]string{
+		{"bench", "-sizes", "x"},
+		{"bench", "-impl", "fastest"},
+		{"bench", "-sizes", "10", "-benchtime", "5ms", "-dir", dir, "-baseline", filepath.Join(dir, "missing.json")},
+	} {
+		if err := run(bad, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+}
+
+// go test -run TestBenchRegression -regress
+// the baseline is updated with go run . bench -update
+func TestBenchRegression(t *testing.T) {
+	if !*regress {
+		t.Skip("run with -regress")
+	}
+	out := new(bytes.Buffer)
+	err := run([]string{"bench", "-dir", t.TempDir()}, out)
+	t.Log("\n" + out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const indexVersion = 1
+
+var (
+	ErrIndexStale      = errors.New("index is stale")
+	ErrIndexCompressed = errors.New("can't index a compressed file")
+)
+
+// Index is an inverted index of a users file, stored next to it in IndexPath.
+// Posting lists are sorted line numbers.
+type Index struct {
+	Version       int
+	SourceSize    int64
+	SourceModTime int64
+
+	// offsets of line starts, the last line ends at SourceSize
+	Offsets []int64
+	// all the distinct browsers, used for "Total unique browsers"
+	Browsers []string
+	// browser token (see browserTokens) -> lines
+	BrowserTokens map[string][]uint32
+	// field name -> exact value -> lines, for all the fields but browsers
+	Fields map[string]map[string][]uint32
+}
+
+func IndexPath(dataPath string) string {
+	return dataPath + ".idx"
+}
+
+func isTokenByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c >= 0x80
+}
+
+// browserTokens splits s into runs of letters and digits,
+// "Mozilla/5.0 (Android)" gives Mozilla, 5, 0, Android.
+func browserTokens(s []byte, tokens [][]byte) [][]byte {
+	tokens = tokens[:0]
+	start := -1
+	for i, c := range s {
+		if isTokenByte(c) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, s[start:i])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+func addPosting(postings map[string][]uint32, key []byte, line uint32) {
+	list := postings[string(key)]
+	if len(list) > 0 && list[len(list)-1] == line {
+		return
+	}
+	postings[string(key)] = append(list, line)
+}
+
+// BuildIndex indexes the users file at dataPath and writes the index to IndexPath
+func BuildIndex(dataPath string) (*Index, error) {
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &Index{
+		Version:       indexVersion,
+		SourceSize:    st.Size(),
+		SourceModTime: st.ModTime().UnixNano(),
+		BrowserTokens: map[string][]uint32{},
+		Fields:        map[string]map[string][]uint32{},
+	}
+	for f, name := range fieldNameList {
+		if field(f) != fieldBrowsers {
+			ix.Fields[name] = map[string][]uint32{}
+		}
+	}
+
+	br := bufio.NewReader(file)
+	head, _ := br.Peek(len(zstdMagic))
+	if detectCompression(head) != compressionNone {
+		return nil, ErrIndexCompressed
+	}
+
+	seenBrowsers := map[string]bool{}
+	rec := &userRecord{}
+	var tokens [][]byte
+	offset := int64(0)
+	for line := uint32(0); offset < st.Size(); line++ {
+		data, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// longer than the buffer, not a problem for an index
+			data = append([]byte{}, data...)
+			var rest []byte
+			rest, err = br.ReadBytes('\n')
+			data = append(data, rest...)
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(data) == 0 {
+			break
+		}
+		ix.Offsets = append(ix.Offsets, offset)
+		offset += int64(len(data))
+
+		if err := scanUser(dropCRLF(data), rec, allFields); err != nil {
+			return nil, &LineError{int(line) + 1, err}
+		}
+		for _, browser := range rec.browsers {
+			if !seenBrowsers[string(browser)] {
+				seenBrowsers[string(browser)] = true
+				ix.Browsers = append(ix.Browsers, string(browser))
+			}
+			tokens = browserTokens(browser, tokens)
+			for _, token := range tokens {
+				addPosting(ix.BrowserTokens, token, line)
+			}
+		}
+		for f, name := range fieldNameList {
+			if field(f) != fieldBrowsers {
+				addPosting(ix.Fields[name], rec.fieldValue(field(f)), line)
+			}
+		}
+	}
+
+	if err := ix.write(IndexPath(dataPath)); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *Index) write(path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(ix)
+	})
+}
+
+// dropCRLF strips the line end the same way bufio.ScanLines does
+func dropCRLF(data []byte) []byte {
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	return bytes.TrimSuffix(data, []byte("\r"))
+}
+
+// OpenIndex reads the index of dataPath, ErrIndexStale is returned if
+// the data file has changed since the index was built.
+func OpenIndex(dataPath string) (*Index, error) {
+	st, err := os.Stat(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(IndexPath(dataPath))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ix := &Index{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(ix); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIndexStale, err)
+	}
+	if ix.Version != indexVersion || ix.SourceSize != st.Size() || ix.SourceModTime != st.ModTime().UnixNano() {
+		return nil, ErrIndexStale
+	}
+	return ix, nil
+}
+
+// OpenOrBuildIndex opens the index of dataPath, rebuilding it if needed
+func OpenOrBuildIndex(dataPath string) (*Index, error) {
+	ix, err := OpenIndex(dataPath)
+	if err == nil {
+		return ix, nil
+	}
+	if !errors.Is(err, ErrIndexStale) && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return BuildIndex(dataPath)
+}
+
+func intersect(a, b []uint32) []uint32 {
+	res := []uint32{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+func union(lists ...[]uint32) []uint32 {
+	res := []uint32{}
+	for _, list := range lists {
+		res = append(res, list...)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	uniq := res[:0]
+	for i, v := range res {
+		if i == 0 || v != res[i-1] {
+			uniq = append(uniq, v)
+		}
+	}
+	return uniq
+}
+
+// candidates returns lines that may match n, all is true if the index
+// can't narrow the search (NOT, !=, regexps).
+func (ix *Index) candidates(n node) (lines []uint32, all bool) {
+	switch n := n.(type) {
+	case andNode:
+		l, lAll := ix.candidates(n.l)
+		r, rAll := ix.candidates(n.r)
+		switch {
+		case lAll:
+			return r, rAll
+		case rAll:
+			return l, false
+		}
+		return intersect(l, r), false
+	case orNode:
+		l, lAll := ix.candidates(n.l)
+		r, rAll := ix.candidates(n.r)
+		if lAll || rAll {
+			return nil, true
+		}
+		return union(l, r), false
+	case *predicate:
+		return ix.predicateCandidates(n)
+	}
+	return nil, true
+}
+
+func (ix *Index) predicateCandidates(p *predicate) ([]uint32, bool) {
+	if p.op != opEq && p.op != opContains || p.value == "" || p.field.isUserAgent() {
+		return nil, true
+	}
+	if p.field != fieldBrowsers {
+		values := ix.Fields[fieldNameList[p.field]]
+		if p.op == opEq {
+			return values[p.value], false
+		}
+		lists := [][]uint32{}
+		for value, list := range values {
+			if strings.Contains(value, p.value) {
+				lists = append(lists, list)
+			}
+		}
+		return union(lists...), false
+	}
+
+	// every token-run of the value is inside some token of a matching browser
+	pieces := browserTokens([]byte(p.value), nil)
+	if len(pieces) == 0 {
+		return nil, true
+	}
+	var res []uint32
+	for i, piece := range pieces {
+		lists := [][]uint32{}
+		for token, list := range ix.BrowserTokens {
+			if strings.Contains(token, string(piece)) {
+				lists = append(lists, list)
+			}
+		}
+		if i == 0 {
+			res = union(lists...)
+		} else {
+			res = intersect(res, union(lists...))
+		}
+	}
+	return res, false
+}
+
+// SearchIndexed gives the same results as SearchFile, but reads only the
+// lines the index points to. The index is (re)built if missing or stale.
+func SearchIndexed(dataPath string, q *Query, sink resultSink) error {
+	ix, err := OpenOrBuildIndex(dataPath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ix.search(file, q, sink)
+}
+
+func (ix *Index) search(data io.ReaderAt, q *Query, sink resultSink) error {
+	lines, all := ix.candidates(q.root)
+	if all {
+		lines = make([]uint32, len(ix.Offsets))
+		for i := range lines {
+			lines[i] = uint32(i)
+		}
+	}
+
+	output := sinkFields(sink)
+	rec := &userRecord{}
+	buf := []byte{}
+	for _, line := range lines {
+		end := ix.SourceSize
+		if int(line)+1 < len(ix.Offsets) {
+			end = ix.Offsets[line+1]
+		}
+		start := ix.Offsets[line]
+		if cap(buf) < int(end-start) {
+			buf = make([]byte, end-start)
+		}
+		buf = buf[:end-start]
+		if _, err := data.ReadAt(buf, start); err != nil && err != io.EOF {
+			return err
+		}
+		lineData := dropCRLF(buf)
+
+		if err := scanUser(lineData, rec, q.fields); err != nil {
+			return &LineError{int(line) + 1, err}
+		}
+		if !q.root.match(rec) {
+			continue
+		}
+		if err := scanUser(lineData, rec, output); err != nil {
+			return &LineError{int(line) + 1, err}
+		}
+		if err := sink.Found(int(line), rec); err != nil {
+			return err
+		}
+	}
+
+	uniqueBrowsers := map[string]bool{}
+	for _, browser := range ix.Browsers {
+		if key, ok := q.browserKey([]byte(browser)); ok {
+			uniqueBrowsers[key] = true
+		}
+	}
+	return sink.Done(len(uniqueBrowsers))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var indexQueries = []string{
+	DefaultQuery,
+	`country = "Kenya"`,
+	`country = "Kenya" OR company contains "oo"`,
+	`browsers contains "MSIE 7.0" AND NOT country = "Kenya"`,
+	`browsers contains "ndroi" AND browsers = "Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0; Trident/5.0)"`,
+	`browsers contains "/" AND name ~ "^S"`,
+	`email ~ "\\.edu$" OR phone = "176-88-49"`,
+	`browsers contains "no such browser"`,
+	`browser.family = "IE" AND browser.os = "Android"`,
+}
+
+func copyDataFile(t *testing.T) string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkIndexedSearch(t *testing.T, path string) {
+	for _, query := range indexQueries {
+		q := MustCompileQuery(query)
+		expected := new(bytes.Buffer)
+		if err := SearchFile(path, q, NewTextSink(expected)); err != nil {
+			t.Fatal(err)
+		}
+		got := new(bytes.Buffer)
+		if err := SearchIndexed(path, q, NewTextSink(got)); err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", query, got, expected)
+		}
+	}
+}
+
+func TestSearchIndexed(t *testing.T) {
+	path := copyDataFile(t)
+	checkIndexedSearch(t, path)
+
+	ix, err := OpenIndex(path)
+	if err != nil {
+		t.Fatalf("index is not persisted: %s", err)
+	}
+	lines, all := ix.candidates(defaultQuery.root)
+	if all || len(lines) == 0 || len(lines) >= len(ix.Offsets)/2 {
+		t.Errorf("index does not narrow the search: %d of %d lines", len(lines), len(ix.Offsets))
+	}
+}
+
+func TestIndexInvalidation(t *testing.T) {
+	path := copyDataFile(t)
+	if _, err := BuildIndex(path); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("\n" + `{"browsers":["MSIE 9.0","Android 7.0"],"email":"new@user.org","name":"New User","country":"Kenya"}`)
+	file.Close()
+	// make sure mtime differs even on coarse file systems
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(path, future, future)
+
+	if _, err := OpenIndex(path); !errors.Is(err, ErrIndexStale) {
+		t.Fatalf("expected stale index, got %v", err)
+	}
+	checkIndexedSearch(t, path)
+	if _, err := OpenIndex(path); err != nil {
+		t.Errorf("index is not rebuilt: %s", err)
+	}
+}
+
+func TestIndexCorrupted(t *testing.T) {
+	path := copyDataFile(t)
+	ioutil.WriteFile(IndexPath(path), []byte("garbage"), 0644)
+	if _, err := OpenIndex(path); !errors.Is(err, ErrIndexStale) {
+		t.Fatalf("expected stale index, got %v", err)
+	}
+	checkIndexedSearch(t, path)
+}
+
+func TestIndexCompressed(t *testing.T) {
+	if _, err := BuildIndex(compressedCopies(t)[0]); !errors.Is(err, ErrIndexCompressed) {
+		t.Errorf("expected ErrIndexCompressed, got %v", err)
+	}
+}
+
+// the index is loaded once, only the query path is measured
+func BenchmarkIndexed(b *testing.B) {
+	dir := b.TempDir()
+	data, _ := ioutil.ReadFile(filePath)
+	path := filepath.Join(dir, "users.txt")
+	ioutil.WriteFile(path, data, 0644)
+	ix, err := BuildIndex(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix.search(file, defaultQuery, NewTextSink(ioutil.Discard))
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// go run . -q 'country = "Kenya"' -in users.txt.gz
+// go run . -follow -state users.state -in users.txt
+// go run . -validate skip -in users.txt
+// go run . bench -sizes 1000,10000
+// go run . convert -in users.txt -out users.col && go run . -in users.col
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) > 0 && args[0] == "bench" {
+		return runBench(args[1:], stdout)
+	}
+	if len(args) > 0 && args[0] == "convert" {
+		return runConvert(args[1:], stdout)
+	}
+	flags := flag.NewFlagSet("fastsearch", flag.ContinueOnError)
+	query := flags.String("q", DefaultQuery, "query, see query.go for the syntax")
+	in := flags.String("in", filePath, `users file, "-" for stdin, may be gzip or zstd compressed or columnar`)
+	format := flags.String("format", "text", "output format: text, json or none")
+	workers := flags.Int("workers", 1, "workers for an uncompressed file, 0 for one per CPU")
+	useIndex := flags.Bool("index", false, "use the index next to the file, it is built if missing or stale")
+	uniqueBy := flags.String("unique", "browsers", "count unique browsers by: browsers, browser.family, browser.version, browser.os or browser.device")
+	report := flags.String("report", "", "comma separated reports: a field like country or browser.family, or "+HistogramReport)
+	reportFormat := flags.String("report-format", ReportTable, "report format: table, csv or json")
+	top := flags.Int("top", 10, "rows per report, 0 for all")
+	approximate := flags.Bool("approx", false, "approximate reports in fixed memory")
+	redactPath := flags.String("redact", "", "json config of PII redaction, see RedactConfig")
+	follow := flags.Bool("follow", false, "keep reading lines appended to the file, until interrupted; a malformed line stops it and is read again on resume, use -validate skip to go past such lines")
+	interval := flags.Duration("interval", time.Second, "how often to check the followed file")
+	statePath := flags.String("state", "", "file to save the follow position in and resume from")
+	validate := flags.String("validate", "", "check lines against UserSchema: skip or fail on invalid ones, violations go to stderr and the file is read by one worker")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	q, err := CompileQuery(*query)
+	if err != nil {
+		return err
+	}
+	if q, err = q.UniqueBy(*uniqueBy); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(stdout)
+	var sink resultSink
+	switch *format {
+	case "text":
+		sink = NewTextSink(out)
+	case "json":
+		sink = NewJSONSink(out)
+	case "none":
+		sink = DiscardSink{}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *report != "" {
+		reports, err := ParseReports(*report, *top, *approximate)
+		if err != nil {
+			return err
+		}
+		if sink, err = NewReportSink(sink, out, *reportFormat, reports); err != nil {
+			return err
+		}
+	}
+	if *redactPath != "" {
+		redactor, err := LoadRedactor(*redactPath)
+		if err != nil {
+			return err
+		}
+		sink = NewRedactSink(sink, redactor)
+	}
+
+	var validator *Validator
+	if *validate != "" {
+		if *useIndex {
+			return fmt.Errorf("-validate does not work with -index")
+		}
+		if validator, err = NewValidator(UserSchema, *validate); err != nil {
+			return err
+		}
+		validator.Report = func(v Violation) error {
+			_, err := fmt.Fprintln(stderr, v)
+			return err
+		}
+	}
+
+	columnar := isColumnarFile(*in)
+	if columnar && (*follow || *useIndex || validator != nil) {
+		return fmt.Errorf("%s is columnar, -follow, -index and -validate need json lines", *in)
+	}
+
+	switch {
+	case columnar:
+		err = SearchColumnar(*in, q, sink)
+	case *follow:
+		err = runFollow(*in, q, validator, sink, out, *interval, *statePath)
+	case *useIndex:
+		err = SearchIndexed(*in, q, sink)
+	case *workers != 1 && validator == nil && isPlainFile(*in):
+		err = ParallelSearchFile(*in, q, *workers, sink)
+	default:
+		err = SearchFileValidated(*in, q, validator, sink)
+	}
+	// the users found before an error are written too
+	flushErr := out.Flush()
+	if validator != nil {
+		// the summary tells how far it got on failure too
+		if _, err := validator.Summary().WriteTo(stderr); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// stderr gets the diagnostics, like schema violations
+var stderr io.Writer = os.Stderr
+
+// followContext is done when following should stop
+var followContext = func() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func runFollow(path string, q *Query, validator *Validator, sink resultSink, out *bufio.Writer, interval time.Duration, statePath string) error {
+	if path == StdinPath {
+		return fmt.Errorf("can't follow stdin")
+	}
+	state := FollowState{}
+	if statePath != "" {
+		data, err := ioutil.ReadFile(statePath)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &state); err != nil {
+				return fmt.Errorf("follow state %s: %w", statePath, err)
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+	}
+
+	ctx, cancel := followContext()
+	defer cancel()
+	f := NewFollower(path, q, sink, state)
+	f.Validator = validator
+	err := f.Run(ctx, interval, func(state FollowState) error {
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		if statePath == "" {
+			return nil
+		}
+		return saveFollowState(statePath, state)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func saveFollowState(path string, state FollowState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// the state must not be half written if we are killed
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// runConvert converts a users file to the columnar format
+func runConvert(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("fastsearch convert", flag.ContinueOnError)
+	in := flags.String("in", filePath, "users file, may be gzip or zstd compressed")
+	outPath := flags.String("out", "", "columnar file to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *outPath == "" {
+		return fmt.Errorf("-out is required")
+	}
+	rows, err := ConvertColumnarFile(*in, *outPath)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "converted %d users to %s\n", rows, *outPath)
+	return err
+}
+
+// isPlainFile reports if path is a regular uncompressed file
+func isPlainFile(path string) bool {
+	if path == StdinPath {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	if st, err := file.Stat(); err != nil || !st.Mode().IsRegular() {
+		return false
+	}
+	head := make([]byte, len(zstdMagic))
+	n, _ := io.ReadFull(file, head)
+	return detectCompression(head[:n]) == compressionNone
+}
+
+// runBench runs the benchmark harness and compares the results with the
+// baseline, an error is returned if some metric regressed
+func runBench(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("fastsearch bench", flag.ContinueOnError)
+	sizes := flags.String("sizes", "1000,10000", "comma separated numbers of users in generated datasets")
+	seed := flags.Int64("seed", 1, "seed of generated datasets")
+	dir := flags.String("dir", os.TempDir(), "directory for generated datasets")
+	impls := flags.String("impl", "", "comma separated implementations to run, all by default")
+	baselinePath := flags.String("baseline", "testdata/bench_baseline.json", "stored results to compare with")
+	update := flags.Bool("update", false, "write the results to the baseline instead of comparing")
+	benchTime := flags.Duration("benchtime", DefaultBenchTime, "how long each implementation runs at least on a dataset")
+	th := DefaultThresholds
+	flags.Float64Var(&th.NsPerOp, "max-ns", th.NsPerOp, "allowed relative growth of ns/op, relative to the one of "+ReferenceImpl)
+	flags.Float64Var(&th.BytesPerOp, "max-bytes", th.BytesPerOp, "allowed relative growth of B/op")
+	flags.Float64Var(&th.AllocsPerOp, "max-allocs", th.AllocsPerOp, "allowed relative growth of allocs/op")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := HarnessConfig{Dir: *dir, Seed: *seed, Impls: SearchImpls, BenchTime: *benchTime}
+	for _, s := range strings.Split(*sizes, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || size <= 0 {
+			return fmt.Errorf("bad dataset size %q", s)
+		}
+		cfg.Sizes = append(cfg.Sizes, size)
+	}
+	if *impls != "" {
+		cfg.Impls = nil
+		for _, name := range strings.Split(*impls, ",") {
+			impl, ok := findImpl(strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("unknown implementation %q", name)
+			}
+			cfg.Impls = append(cfg.Impls, impl)
+		}
+	}
+
+	results, err := RunHarness(cfg)
+	if err != nil {
+		return err
+	}
+	if *update {
+		if err := NewBaseline(results).Save(*baselinePath); err != nil {
+			return err
+		}
+		return writeBenchResults(stdout, results, nil)
+	}
+	baseline, err := LoadBaseline(*baselinePath)
+	if err != nil {
+		return err
+	}
+	if err := writeBenchResults(stdout, results, baseline); err != nil {
+		return err
+	}
+	if regressions := baseline.Compare(results, th); len(regressions) > 0 {
+		lines := []string{}
+		for _, r := range regressions {
+			lines = append(lines, r.String())
+		}
+		return fmt.Errorf("performance regressions:\n%s", strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+func findImpl(name string) (SearchImpl, bool) {
+	for _, impl := range SearchImpls {
+		if impl.Name == name {
+			return impl, true
+		}
+	}
+	return SearchImpl{}, false
+}
+
+// formatRelative formats a relativeTime, empty for none
+func formatRelative(ratio float64) string {
+	if ratio == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(ratio, 'f', 4, 64)
+}
+
+func writeBenchResults(out io.Writer, results map[string]BenchResult, baseline *Baseline) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "benchmark\tns/op\tof %s\tB/op\tallocs/op\t\n", ReferenceImpl)
+	for _, name := range sortedNames(results) {
+		res := results[name]
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t\n", name, res.NsPerOp, formatRelative(relativeTime(results, name)), res.BytesPerOp, res.AllocsPerOp)
+		if base, ok := baseline.result(name); ok {
+			fmt.Fprintf(w, "baseline\t%d\t%s\t%d\t%d\t\n", base.NsPerOp, formatRelative(relativeTime(baseline.Results, name)), base.BytesPerOp, base.AllocsPerOp)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+type parallelMatch struct {
+	line int // line number inside the chunk
+	user UserType
+}
+
+type chunkResult struct {
+	lines        int
+	matches      []parallelMatch
+	seenBrowsers map[string]bool
+	err          error
+}
+
+// ParallelSearch is FastSearchQuery over the data file split into chunks
+// processed by workers goroutines. The output is the same as of FastSearchQuery.
+func ParallelSearch(out io.Writer, q *Query, workers int) error {
+	return ParallelSearchFile(filePath, q, workers, NewTextSink(out))
+}
+
+// parallelChunkSize is about the bytes of a chunk. A file has many more
+// chunks than workers, so that the matches held in memory are of a few
+// chunks only and the first ones are written before the scan ends.
+var parallelChunkSize int64 = 4 << 20
+
+// ParallelSearchFile works like SearchFile, path must be an uncompressed file.
+// The chunks are written in file order as soon as they and the chunks
+// before them are done.
+func ParallelSearchFile(path string, q *Query, workers int, sink resultSink) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	chunks := int((st.Size() + parallelChunkSize - 1) / parallelChunkSize)
+	if chunks < workers {
+		chunks = workers
+	}
+	bounds, err := chunkBounds(file, st.Size(), chunks)
+	if err != nil {
+		return err
+	}
+
+	type chunkJob struct {
+		c      int
+		result chan chunkResult
+	}
+	// pending limits the chunks done but not written yet
+	pending := make(chan chan chunkResult, 2*workers)
+	jobs := make(chan chunkJob)
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	// the workers are done with the file before it is closed
+	defer wg.Wait()
+	defer close(stop)
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for c := 0; c < len(bounds)-1; c++ {
+			job := chunkJob{c, make(chan chunkResult, 1)}
+			select {
+			case pending <- job.result:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- job:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	output := sinkFields(sink)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				res := chunkResult{seenBrowsers: map[string]bool{}}
+				section := io.NewSectionReader(file, bounds[job.c], bounds[job.c+1]-bounds[job.c])
+				res.lines, res.err = searchLines(section, 0, q, nil, output, res.seenBrowsers, func(i int, user *userRecord) error {
+					res.matches = append(res.matches, parallelMatch{i, user.User()})
+					return nil
+				})
+				job.result <- res
+			}
+		}()
+	}
+
+	// chunks go in file order, so line numbers are shifted by lines of previous chunks
+	seenBrowsers := map[string]bool{}
+	lineOffset := 0
+	for result := range pending {
+		res := <-result
+		// the matches before a bad line are written, as FastSearchQuery does
+		for _, m := range res.matches {
+			if err := sink.Found(lineOffset+m.line, recordFromUser(&m.user)); err != nil {
+				return err
+			}
+		}
+		if res.err != nil {
+			if lineErr, ok := res.err.(*LineError); ok {
+				lineErr.Line += lineOffset
+			}
+			return res.err
+		}
+		for browser := range res.seenBrowsers {
+			seenBrowsers[browser] = true
+		}
+		lineOffset += res.lines
+	}
+	return sink.Done(len(seenBrowsers))
+}
+
+// chunkBounds splits [0, size) into at most n chunks starting right after a '\n'.
+// The result has the offsets of chunk starts followed by size.
+func chunkBounds(r io.ReaderAt, size int64, n int) ([]int64, error) {
+	bounds := []int64{0}
+	buf := make([]byte, 4096)
+	for k := 1; k < n; k++ {
+		pos := size * int64(k) / int64(n)
+		if prev := bounds[len(bounds)-1]; pos <= prev {
+			continue
+		}
+		// move pos to the byte after the next '\n'
+		for pos < size {
+			m, err := r.ReadAt(buf, pos)
+			if idx := bytes.IndexByte(buf[:m], '\n'); idx >= 0 {
+				pos += int64(idx) + 1
+				break
+			}
+			pos += int64(m)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if pos >= size {
+			break
+		}
+		if pos > bounds[len(bounds)-1] {
+			bounds = append(bounds, pos)
+		}
+	}
+	return append(bounds, size), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setChunkSize sets parallelChunkSize for the test
+func setChunkSize(t *testing.T, size int64) {
+	old := parallelChunkSize
+	parallelChunkSize = size
+	t.Cleanup(func() { parallelChunkSize = old })
+}
+
+func TestParallelSearch(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+
+	for _, workers := range []int{0, 1, 2, 3, 7, 16, 1000} {
+		parallelOut := new(bytes.Buffer)
+		if err := ParallelSearch(parallelOut, defaultQuery, workers); err != nil {
+			t.Fatal(err)
+		}
+		if fastOut.String() != parallelOut.String() {
+			t.Errorf("%d workers: results not match\nGot:\n%v\nExpected:\n%v", workers, parallelOut, fastOut)
+		}
+	}
+
+	// many more chunks than workers
+	for _, size := range []int64{1, 1000, 64 << 10} {
+		setChunkSize(t, size)
+		for _, workers := range []int{1, 3} {
+			parallelOut := new(bytes.Buffer)
+			if err := ParallelSearch(parallelOut, defaultQuery, workers); err != nil {
+				t.Fatal(err)
+			}
+			if fastOut.String() != parallelOut.String() {
+				t.Errorf("%d bytes chunks, %d workers: results not match\nGot:\n%v\nExpected:\n%v", size, workers, parallelOut, fastOut)
+			}
+		}
+	}
+}
+
+func TestParallelSearchSmallFile(t *testing.T) {
+	lines := []string{
+		`{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}`,
+		`{"browsers":["Chrome"],"email":"d@e.f","name":"B"}`,
+		`{"browsers":["Android 2.0","MSIE 6.0"],"email":"g@h.i","name":"C"}`,
+	}
+	expected := "found users:\n[0] A <a [at] b.c>\n[2] C <g [at] h.i>\n\nTotal unique browsers 4\n"
+
+	for _, content := range []string{strings.Join(lines, "\n"), strings.Join(lines, "\n") + "\n"} {
+		path := filepath.Join(t.TempDir(), "users.txt")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for workers := 1; workers <= 10; workers++ {
+			out := new(bytes.Buffer)
+			if err := ParallelSearchFile(path, defaultQuery, workers, NewTextSink(out)); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != expected {
+				t.Errorf("%d workers: results not match\nGot:\n%v\nExpected:\n%v", workers, out, expected)
+			}
+		}
+	}
+}
+
+func TestParallelSearchBadLine(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf(`{"browsers":["MSIE 8.0","Android 4.0"],"email":"u%d@b.c","name":"U%d"}`, i, i))
+	}
+	// in the middle of a later chunk for most worker counts
+	lines[30] = `{"browsers":["MSIE 8.0",`
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := new(bytes.Buffer)
+	expectedErr := SearchFile(path, defaultQuery, NewTextSink(expected))
+	if expectedErr == nil || !strings.Contains(expected.String(), "[29] U29") {
+		t.Fatalf("expected the users before the bad line and an error, got %v\n%s", expectedErr, expected)
+	}
+	for _, size := range []int64{parallelChunkSize, 200} {
+		setChunkSize(t, size)
+		for workers := 1; workers <= 10; workers++ {
+			out := new(bytes.Buffer)
+			err := ParallelSearchFile(path, defaultQuery, workers, NewTextSink(out))
+			if err == nil || err.Error() != expectedErr.Error() {
+				t.Errorf("%d bytes chunks, %d workers: expected error %v, got %v", size, workers, expectedErr, err)
+			}
+			if out.String() != expected.String() {
+				t.Errorf("%d bytes chunks, %d workers: results not match\nGot:\n%v\nExpected:\n%v", size, workers, out, expected)
+			}
+		}
+	}
+}
+
+// stopSink fails on the first user found
+type stopSink struct {
+	found int
+}
+
+var errStopSink = errors.New("stop")
+
+func (s *stopSink) Found(i int, user *userRecord) error {
+	s.found++
+	return errStopSink
+}
+
+func (s *stopSink) Done(uniqueBrowsers int) error {
+	return nil
+}
+
+func TestParallelSearchSinkError(t *testing.T) {
+	setChunkSize(t, 1000)
+	sink := &stopSink{}
+	if err := ParallelSearchFile(filePath, defaultQuery, 3, sink); err != errStopSink || sink.found != 1 {
+		t.Errorf("expected to stop at the first user, got %v after %d users", err, sink.found)
+	}
+}
+
+func TestChunkBounds(t *testing.T) {
+	data := "aaaa\nbb\nc\ndddddd\n"
+	file, err := ioutil.TempFile(t.TempDir(), "chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(data)
+
+	for n := 1; n <= 20; n++ {
+		bounds, err := chunkBounds(file, int64(len(data)), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bounds[0] != 0 || bounds[len(bounds)-1] != int64(len(data)) || len(bounds) > n+1 {
+			t.Errorf("%d: bad bounds %v", n, bounds)
+		}
+		for _, b := range bounds[1 : len(bounds)-1] {
+			if data[b-1] != '\n' {
+				t.Errorf("%d: bound %d is not at line start: %v", n, b, bounds)
+			}
+		}
+	}
+}
+
+// -----
+// go test -bench . -benchmem
+
+// the data file of BenchmarkSlow with fixed worker counts, so that the
+// results are comparable between machines with enough CPUs:
+// go test -bench 'Slow|Parallel' -benchmem
+func BenchmarkParallel(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := ParallelSearch(ioutil.Discard, defaultQuery, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Query language for FastSearchQuery:
+//
+//	expr      = or
+//	or        = and { "OR" and }
+//	and       = not { "AND" not }
+//	not       = "NOT" not | "(" expr ")" | predicate
+//	predicate = field ( "=" | "!=" | "contains" | "~" ) "string"
+//
+// fields are the json keys of UserType: name, email, browsers, company,
+// country, job, phone. A predicate on browsers is true if any of the
+// browsers matches. "~" is a regexp match. Keywords are case insensitive.
+//
+// browser.family, browser.version, browser.os and browser.device are the
+// facts ParseUserAgent extracts from each of the browsers, a predicate on
+// them is true if any of the browsers matches. Separate predicates may be
+// satisfied by different browsers of the same user.
+//
+// Example: browsers contains "Android" AND (country = "Kenya" OR NOT email ~ "\\.edu$")
+// Example: browser.family = "IE" AND browser.os = "Android"
+
+const DefaultQuery = `browsers contains "Android" AND browsers contains "MSIE"`
+
+type field int
+
+const (
+	fieldName field = iota
+	fieldEmail
+	fieldBrowsers
+	fieldCompany
+	fieldCountry
+	fieldJob
+	fieldPhone
+
+	// parsed from browsers, see ParseUserAgent
+	fieldUAFamily
+	fieldUAVersion
+	fieldUAOS
+	fieldUADevice
+)
+
+func (f field) isUserAgent() bool {
+	return f >= fieldUAFamily
+}
+
+var fieldNames = map[string]field{
+	"name":     fieldName,
+	"email":    fieldEmail,
+	"browsers": fieldBrowsers,
+	"company":  fieldCompany,
+	"country":  fieldCountry,
+	"job":      fieldJob,
+	"phone":    fieldPhone,
+
+	"browser.family":  fieldUAFamily,
+	"browser.version": fieldUAVersion,
+	"browser.os":      fieldUAOS,
+	"browser.device":  fieldUADevice,
+}
+
+type op int
+
+const (
+	opEq op = iota
+	opNe
+	opContains
+	opRegexp
+)
+
+type node interface {
+	match(r *userRecord) bool
+}
+
+type andNode struct{ l, r node }
+type orNode struct{ l, r node }
+type notNode struct{ n node }
+
+func (n andNode) match(r *userRecord) bool { return n.l.match(r) && n.r.match(r) }
+func (n orNode) match(r *userRecord) bool  { return n.l.match(r) || n.r.match(r) }
+func (n notNode) match(r *userRecord) bool { return !n.n.match(r) }
+
+type predicate struct {
+	field  field
+	op     op
+	value  string
+	bvalue []byte
+	re     *regexp.Regexp
+}
+
+func (p *predicate) matchBytes(b []byte) bool {
+	switch p.op {
+	case opEq:
+		return string(b) == p.value
+	case opNe:
+		return string(b) != p.value
+	case opContains:
+		return bytes.Contains(b, p.bvalue)
+	case opRegexp:
+		return p.re.Match(b)
+	}
+	return false
+}
+
+func (p *predicate) matchString(s string) bool {
+	switch p.op {
+	case opEq:
+		return s == p.value
+	case opNe:
+		return s != p.value
+	case opContains:
+		return strings.Contains(s, p.value)
+	case opRegexp:
+		return p.re.MatchString(s)
+	}
+	return false
+}
+
+// matchBrowser matches a single browser, for browsers and browser.* predicates
+func (p *predicate) matchBrowser(browser []byte) bool {
+	if p.field.isUserAgent() {
+		return p.matchString(userAgentOf(browser).Field(p.field))
+	}
+	return p.matchBytes(browser)
+}
+
+func (p *predicate) match(r *userRecord) bool {
+	if p.field != fieldBrowsers && !p.field.isUserAgent() {
+		return p.matchBytes(r.fieldValue(p.field))
+	}
+	for _, browser := range r.browsers {
+		if p.matchBrowser(browser) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query is a compiled query, safe for concurrent use.
+type Query struct {
+	src  string
+	root node
+	// fields used by the query
+	fields fieldSet
+	// predicates on browsers, a browser matching any of them is counted
+	// in "Total unique browsers"
+	browserTerms []*predicate
+	// what is counted as a unique browser, see UniqueBy
+	uniqueBy field
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+func (q *Query) Match(u *UserType) bool {
+	return q.root.match(recordFromUser(u))
+}
+
+func recordFromUser(u *UserType) *userRecord {
+	r := &userRecord{
+		name:    []byte(u.Name),
+		email:   []byte(u.Email),
+		company: []byte(u.Company),
+		country: []byte(u.Country),
+		job:     []byte(u.Job),
+		phone:   []byte(u.Phone),
+	}
+	for _, b := range u.Browsers {
+		r.browsers = append(r.browsers, []byte(b))
+	}
+	return r
+}
+
+// UniqueBy returns a copy of q that counts unique browsers by a browser.*
+// field instead of the raw browsers string, so "Total unique browsers" of
+// browser.family is the number of distinct browser families. "browsers"
+// restores the default. browser.version counts family and version together.
+func (q *Query) UniqueBy(name string) (*Query, error) {
+	f, ok := fieldNames[strings.ToLower(name)]
+	if !ok || f != fieldBrowsers && !f.isUserAgent() {
+		return nil, fmt.Errorf("query: can't count unique browsers by %q", name)
+	}
+	res := *q
+	res.uniqueBy = f
+	return &res, nil
+}
+
+// browserKey returns the key browser is counted under in "Total unique
+// browsers", ok is false if it matches none of the browser predicates.
+func (q *Query) browserKey(browser []byte) (key string, ok bool) {
+	for _, p := range q.browserTerms {
+		if p.matchBrowser(browser) {
+			return q.uniqueKey(browser), true
+		}
+	}
+	return "", false
+}
+
+func (q *Query) uniqueKey(browser []byte) string {
+	return browserValue(q.uniqueBy, browser)
+}
+
+// browserValue is the value of browsers or a browser.* field f for a single
+// browser, browser.version goes together with the family: "IE 7.0".
+func browserValue(f field, browser []byte) string {
+	switch {
+	case f == fieldUAVersion:
+		return userAgentOf(browser).familyVersion
+	case f.isUserAgent():
+		return userAgentOf(browser).Field(f)
+	}
+	return string(browser)
+}
+
+// seenBrowsers marks browsers of r that match one of the browser predicates.
+// For DefaultQuery these are the Android and MSIE browsers.
+func (q *Query) seenBrowsers(r *userRecord, seen map[string]bool) {
+	for _, browser := range r.browsers {
+		for _, p := range q.browserTerms {
+			if !p.matchBrowser(browser) {
+				continue
+			}
+			if q.uniqueBy != fieldBrowsers {
+				seen[q.uniqueKey(browser)] = true
+			} else if !seen[string(browser)] {
+				seen[string(browser)] = true
+			}
+			break
+		}
+	}
+}
+
+func CompileQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	q := &Query{src: src, uniqueBy: fieldBrowsers}
+	root, err := p.parseOr(q)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.pos])
+	}
+	q.root = root
+	return q, nil
+}
+
+func MustCompileQuery(src string) *Query {
+	q, err := CompileQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	return fmt.Sprintf("%q", t.text)
+}
+
+type queryParser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	at := len(p.src)
+	if p.pos < len(p.tokens) {
+		at = p.tokens[p.pos].pos
+	}
+	return fmt.Errorf("query: at %d: %s", at, fmt.Sprintf(format, args...))
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
+
+func (p *queryParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{tokRParen, ")", i})
+			i++
+		case c == '=' || c == '~':
+			p.tokens = append(p.tokens, token{tokOp, s[i : i+1], i})
+			i++
+		case c == '!' && i+1 < len(s) && s[i+1] == '=':
+			p.tokens = append(p.tokens, token{tokOp, "!=", i})
+			i += 2
+		case c == '"':
+			start := i
+			sb := strings.Builder{}
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return fmt.Errorf("query: at %d: unterminated string", start)
+			}
+			i++
+			p.tokens = append(p.tokens, token{tokString, sb.String(), start})
+		case isWordByte(c):
+			start := i
+			for i < len(s) && isWordByte(s[i]) {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokWord, s[start:i], start})
+		default:
+			return fmt.Errorf("query: at %d: unexpected character %q", i, c)
+		}
+	}
+	return nil
+}
+
+func (p *queryParser) keyword(kw string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokWord && strings.EqualFold(p.tokens[p.pos].text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOr(q *Query) (node, error) {
+	l, err := p.parseAnd(q)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		r, err := p.parseAnd(q)
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *queryParser) parseAnd(q *Query) (node, error) {
+	l, err := p.parseNot(q)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		r, err := p.parseNot(q)
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *queryParser) parseNot(q *Query) (node, error) {
+	if p.keyword("NOT") {
+		n, err := p.parseNot(q)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokLParen {
+		p.pos++
+		n, err := p.parseOr(q)
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokRParen {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return n, nil
+	}
+	return p.parsePredicate(q)
+}
+
+func (p *queryParser) parsePredicate(q *Query) (node, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokWord {
+		return nil, p.errorf("expected field name")
+	}
+	f, ok := fieldNames[strings.ToLower(p.tokens[p.pos].text)]
+	if !ok {
+		return nil, p.errorf("unknown field %s", p.tokens[p.pos])
+	}
+	p.pos++
+
+	pred := &predicate{field: f}
+	if f.isUserAgent() {
+		q.fields |= fieldBrowsers.bit()
+	} else {
+		q.fields |= f.bit()
+	}
+	switch {
+	case p.keyword("contains"):
+		pred.op = opContains
+	case p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokOp:
+		switch p.tokens[p.pos].text {
+		case "=":
+			pred.op = opEq
+		case "!=":
+			pred.op = opNe
+		case "~":
+			pred.op = opRegexp
+		}
+		p.pos++
+	default:
+		return nil, p.errorf("expected operator")
+	}
+
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokString {
+		return nil, p.errorf("expected string")
+	}
+	pred.value = p.tokens[p.pos].text
+	pred.bvalue = []byte(pred.value)
+	if pred.op == opRegexp {
+		re, err := regexp.Compile(pred.value)
+		if err != nil {
+			return nil, p.errorf("bad regexp: %s", err)
+		}
+		pred.re = re
+	}
+	p.pos++
+
+	if (f == fieldBrowsers || f.isUserAgent()) && pred.op != opNe {
+		q.browserTerms = append(q.browserTerms, pred)
+	}
+	return pred, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestQueryMatch(t *testing.T) {
+	user := &UserType{
+		Name:     "Susan Ellis",
+		Email:    "eum_rerum_explicabo@Topiczoom.info",
+		Country:  "Kenya",
+		Company:  "Jatri",
+		Browsers: []string{"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0; Trident/5.0)", "Opera/9.80 (Android 2.3.3)"},
+	}
+	cases := []struct {
+		query string
+		match bool
+	}{
+		{DefaultQuery, true},
+		{`browsers contains "Android" and browsers contains "Chrome"`, false},
+		{`country = "Kenya"`, true},
+		{`country != "Kenya"`, false},
+		{`NOT country = "Kenya"`, false},
+		{`company = "X" OR name ~ "^Susan "`, true},
+		{`company = "X" OR name ~ "^Ellis"`, false},
+		{`country = "Kenya" AND (email ~ "\\.info$" OR company = "X")`, true},
+		{`NOT (country = "Kenya" AND company = "Jatri")`, false},
+		{`browsers ~ "MSIE [67]\\."`, true},
+	}
+	for _, c := range cases {
+		q, err := CompileQuery(c.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.query, err)
+			continue
+		}
+		if got := q.Match(user); got != c.match {
+			t.Errorf("%s: expected %v, got %v", c.query, c.match, got)
+		}
+	}
+}
+
+func TestQueryCompileErrors(t *testing.T) {
+	cases := []string{
+		``,
+		`country`,
+		`country = `,
+		`country = Kenya`,
+		`country = "Kenya`,
+		`country = "Kenya" AND`,
+		`(country = "Kenya"`,
+		`country = "Kenya")`,
+		`country like "Kenya"`,
+		`unknown = "x"`,
+		`name ~ "("`,
+		`country = "a" & company = "b"`,
+	}
+	for _, c := range cases {
+		if _, err := CompileQuery(c); err == nil {
+			t.Errorf("%q: expected error", c)
+		}
+	}
+}
+
+func TestFastSearchQueryDefault(t *testing.T) {
+	expected := new(bytes.Buffer)
+	SlowSearch(expected)
+	got := new(bytes.Buffer)
+	if err := FastSearchQuery(got, MustCompileQuery(DefaultQuery)); err != nil {
+		t.Fatal(err)
+	}
+	if expected.String() != got.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+}
+
+func TestFastSearchQueryCountry(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n"
+	for i, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		user := UserType{}
+		if err := json.Unmarshal([]byte(line), &user); err != nil {
+			t.Fatal(err)
+		}
+		if user.Country != "Kenya" || strings.Contains(strings.Join(user.Browsers, "\n"), "Chrome") {
+			continue
+		}
+		expected += fmt.Sprintf("[%d] %s <%s>\n", i, user.Name, strings.ReplaceAll(user.Email, "@", " [at] "))
+	}
+
+	out := new(bytes.Buffer)
+	if err := FastSearchQuery(out, MustCompileQuery(`country = "Kenya" AND NOT browsers contains "Chrome"`)); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()[:strings.LastIndex(out.String(), "\nTotal")]
+	if got != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+}
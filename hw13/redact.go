@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"unicode/utf8"
+)
+
+// Redaction actions for RedactConfig.Fields
+const (
+	RedactKeep     = "keep"     // the value as is
+	RedactDrop     = "drop"     // an empty value
+	RedactMask     = "mask"     // the first letter only: j***@example.com, S***
+	RedactHash     = "hash"     // sha256 of the value, the same everywhere
+	RedactTokenize = "tokenize" // keyed HMAC-SHA256, joinable only with the key
+)
+
+// RedactConfig is the json config of a Redactor:
+//
+//	{
+//		"key_env": "PII_KEY",
+//		"fields": {"email": "mask", "name": "tokenize", "phone": "drop"}
+//	}
+//
+// Fields are the json keys of UserType, the missing ones are kept.
+// For browsers the action applies to every browser.
+type RedactConfig struct {
+	// Key is the secret for tokenize, KeyEnv names an environment
+	// variable with the key instead
+	Key    string            `json:"key,omitempty"`
+	KeyEnv string            `json:"key_env,omitempty"`
+	Fields map[string]string `json:"fields"`
+}
+
+// digests are cut to 16 bytes, 32 hex digits
+const redactDigestSize = 16
+
+const tokenPrefix = "tok_"
+
+// Redactor rewrites PII in found users before they are written out
+type Redactor struct {
+	actions [fieldPhone + 1]string
+	key     []byte
+}
+
+func NewRedactor(cfg RedactConfig) (*Redactor, error) {
+	r := &Redactor{key: []byte(cfg.Key)}
+	if cfg.KeyEnv != "" {
+		r.key = []byte(os.Getenv(cfg.KeyEnv))
+	}
+	for f := range r.actions {
+		r.actions[f] = RedactKeep
+	}
+	for name, action := range cfg.Fields {
+		f, ok := fieldNames[name]
+		if !ok || f.isUserAgent() {
+			return nil, fmt.Errorf("redact: unknown field %q", name)
+		}
+		switch action {
+		case RedactKeep, RedactDrop, RedactMask, RedactHash:
+		case RedactTokenize:
+			if len(r.key) == 0 {
+				return nil, fmt.Errorf("redact: %s: tokenize needs a key", name)
+			}
+		default:
+			return nil, fmt.Errorf("redact: %s: unknown action %q", name, action)
+		}
+		r.actions[f] = action
+	}
+	return r, nil
+}
+
+// LoadRedactor reads a RedactConfig from a json file
+func LoadRedactor(path string) (*Redactor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := RedactConfig{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("redact: %s: %w", path, err)
+	}
+	return NewRedactor(cfg)
+}
+
+// redact appends the redacted value of field f to dst
+func (r *Redactor) redact(f field, value []byte, dst []byte) []byte {
+	switch r.actions[f] {
+	case RedactDrop:
+		return dst
+	case RedactMask:
+		return appendMasked(dst, f, value)
+	case RedactHash:
+		sum := sha256.Sum256(value)
+		return appendHex(dst, sum[:redactDigestSize])
+	case RedactTokenize:
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write(value)
+		dst = append(dst, tokenPrefix...)
+		return appendHex(dst, mac.Sum(nil)[:redactDigestSize])
+	}
+	return append(dst, value...)
+}
+
+func appendHex(dst []byte, b []byte) []byte {
+	var buf [2 * redactDigestSize]byte
+	n := hex.Encode(buf[:], b)
+	return append(dst, buf[:n]...)
+}
+
+// appendMasked keeps the first letter, and the domain of an email
+func appendMasked(dst []byte, f field, value []byte) []byte {
+	if len(value) == 0 {
+		return dst
+	}
+	local, domain := value, []byte(nil)
+	if f == fieldEmail {
+		if at := bytes.LastIndexByte(value, '@'); at >= 0 {
+			local, domain = value[:at], value[at:]
+		}
+	}
+	if len(local) > 0 {
+		_, size := utf8.DecodeRune(local)
+		dst = append(dst, local[:size]...)
+	}
+	dst = append(dst, "***"...)
+	return append(dst, domain...)
+}
+
+// RedactSink redacts found users and passes them to another sink. It goes
+// outside of a ReportSink, so that reports get redacted values too.
+type RedactSink struct {
+	sink     resultSink
+	redactor *Redactor
+	rec      userRecord
+	bufs     [fieldPhone + 1][]byte
+	browsers [][]byte
+}
+
+func NewRedactSink(sink resultSink, redactor *Redactor) *RedactSink {
+	return &RedactSink{sink: sink, redactor: redactor}
+}
+
+func (s *RedactSink) fields() fieldSet {
+	return sinkFields(s.sink)
+}
+
+func (s *RedactSink) Found(i int, user *userRecord) error {
+	for f := fieldName; f <= fieldPhone; f++ {
+		if f == fieldBrowsers {
+			continue
+		}
+		s.bufs[f] = s.redactor.redact(f, user.fieldValue(f), s.bufs[f][:0])
+		s.rec.setField(f, s.bufs[f])
+	}
+
+	s.rec.browsers = s.rec.browsers[:0]
+	for j, browser := range user.browsers {
+		if j == len(s.browsers) {
+			s.browsers = append(s.browsers, nil)
+		}
+		s.browsers[j] = s.redactor.redact(fieldBrowsers, browser, s.browsers[j][:0])
+		s.rec.browsers = append(s.rec.browsers, s.browsers[j])
+	}
+	return s.sink.Found(i, &s.rec)
+}
+
+func (s *RedactSink) Done(uniqueBrowsers int) error {
+	return s.sink.Done(uniqueBrowsers)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := NewRedactor(RedactConfig{
+		Key: "secret",
+		Fields: map[string]string{
+			"email":    RedactMask,
+			"name":     RedactMask,
+			"company":  RedactHash,
+			"phone":    RedactDrop,
+			"job":      RedactTokenize,
+			"browsers": RedactHash,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		field    field
+		value    string
+		expected string
+	}{
+		{fieldEmail, "john.doe@example.com", "j***@example.com"},
+		{fieldEmail, "broken", "b***"},
+		{fieldEmail, "", ""},
+		{fieldName, "Élodie Martin", "É***"},
+		// the first half of sha256("Jatri")
+		{fieldCompany, "Jatri", "4ca607363f2724770812cd56ee8d9e6d"},
+		{fieldPhone, "176-88-49", ""},
+		{fieldCountry, "Kenya", "Kenya"},
+	}
+	for _, c := range cases {
+		got := string(r.redact(c.field, []byte(c.value), nil))
+		if got != c.expected {
+			t.Errorf("%s: got %q, expected %q", c.value, got, c.expected)
+		}
+	}
+
+	// tokens can be joined across runs with the same key only
+	token := string(r.redact(fieldJob, []byte("Engineer"), nil))
+	if !strings.HasPrefix(token, tokenPrefix) || token != string(r.redact(fieldJob, []byte("Engineer"), nil)) {
+		t.Errorf("bad token %q", token)
+	}
+	other, _ := NewRedactor(RedactConfig{Key: "other", Fields: map[string]string{"job": RedactTokenize, "company": RedactHash}})
+	if string(other.redact(fieldJob, []byte("Engineer"), nil)) == token {
+		t.Errorf("tokens do not depend on the key")
+	}
+	if string(r.redact(fieldCompany, []byte("Jatri"), nil)) != string(other.redact(fieldCompany, []byte("Jatri"), nil)) {
+		t.Errorf("hashes depend on the key")
+	}
+
+	for _, cfg := range []RedactConfig{
+		{Fields: map[string]string{"salary": RedactDrop}},
+		{Fields: map[string]string{"browser.os": RedactDrop}},
+		{Fields: map[string]string{"email": "encrypt"}},
+		{Fields: map[string]string{"email": RedactTokenize}},
+		{KeyEnv: "NO_SUCH_PII_KEY", Fields: map[string]string{"email": RedactTokenize}},
+	} {
+		if _, err := NewRedactor(cfg); err == nil {
+			t.Errorf("%v: expected error", cfg)
+		}
+	}
+}
+
+// checkNoLeak fails the test if a raw value is in out. The values are
+// looked up all at once, one by one it takes seconds.
+func checkNoLeak(t *testing.T, out string, raw []string) {
+	pairs := make([]string, 0, 2*len(raw))
+	for _, v := range raw {
+		pairs = append(pairs, v, "")
+	}
+	if strings.NewReplacer(pairs...).Replace(out) == out {
+		return
+	}
+	for _, v := range raw {
+		if strings.Contains(out, v) {
+			t.Fatalf("raw value %q is written", v)
+		}
+	}
+}
+
+// rawValues returns values of fields of all the users in the data file
+func rawValues(t *testing.T, fields ...func(u *UserType) string) []string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	values := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		user := &UserType{}
+		if err := json.Unmarshal(scanner.Bytes(), user); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range fields {
+			// "[at]" is how emails are printed by TextSink
+			if v := f(user); len(v) > 3 {
+				values = append(values, v, strings.ReplaceAll(v, "@", " [at] "))
+			}
+		}
+	}
+	return values
+}
+
+func TestRedactNoLeak(t *testing.T) {
+	name := func(u *UserType) string { return u.Name }
+	email := func(u *UserType) string { return u.Email }
+	phone := func(u *UserType) string { return u.Phone }
+	company := func(u *UserType) string { return u.Company }
+
+	cases := []struct {
+		cfg RedactConfig
+		raw []string
+	}{
+		// masked emails keep the domain, which is often the company
+		{RedactConfig{Fields: map[string]string{"name": RedactMask, "email": RedactMask, "phone": RedactDrop}},
+			rawValues(t, name, email, phone)},
+		{RedactConfig{Key: "secret", Fields: map[string]string{"name": RedactHash, "email": RedactTokenize, "phone": RedactHash, "company": RedactTokenize}},
+			rawValues(t, name, email, phone, company)},
+	}
+	// all the users are found
+	q := MustCompileQuery(`name != "no such user"`)
+	for _, c := range cases {
+		redactor, err := NewRedactor(c.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		for _, sink := range []resultSink{NewTextSink(out), NewJSONSink(out)} {
+			reports, err := ParseReports("name,email,phone,company", 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reportSink, err := NewReportSink(sink, out, ReportCSV, reports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := SearchFile(filePath, q, NewRedactSink(reportSink, redactor)); err != nil {
+				t.Fatal(err)
+			}
+			if err := ParallelSearchFile(filePath, q, 4, NewRedactSink(sink, redactor)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkNoLeak(t, out.String(), c.raw)
+		if !strings.Contains(out.String(), "[999]") {
+			t.Errorf("%v: not all users are written", c.cfg)
+		}
+	}
+}
+
+func TestRunCLIRedact(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "redact.json")
+	ioutil.WriteFile(path, []byte(`{"key_env": "TEST_PII_KEY", "fields": {"email": "tokenize", "name": "mask"}}`), 0644)
+	os.Setenv("TEST_PII_KEY", "secret")
+	defer os.Unsetenv("TEST_PII_KEY")
+
+	out := new(bytes.Buffer)
+	if err := run([]string{"-redact", path, "-format", "json", "-report", "email", "-top", "3"}, out); err != nil {
+		t.Fatal(err)
+	}
+	checkNoLeak(t, out.String(), rawValues(t, func(u *UserType) string { return u.Name }, func(u *UserType) string { return u.Email }))
+	if !strings.Contains(out.String(), `"email":"tok_`) {
+		t.Errorf("emails are not tokenized:\n%s", out)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	for _, cfg := range []string{
+		`{"fields": {"email": "tokenize"}}`,
+		`{"fields": {"email": "mask"}, "salt": "x"}`,
+		`{"fields": `,
+	} {
+		ioutil.WriteFile(bad, []byte(cfg), 0644)
+		if err := run([]string{"-redact", bad}, ioutil.Discard); err == nil {
+			t.Errorf("%s: expected error", cfg)
+		}
+	}
+	if err := run([]string{"-redact", filepath.Join(dir, "missing.json")}, ioutil.Discard); err == nil {
+		t.Errorf("expected error for a missing config")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// HistogramReport is the name of the report of users by the number of browsers
+const HistogramReport = "browser_count"
+
+// Report formats for WriteReports
+const (
+	ReportTable = "table"
+	ReportCSV   = "csv"
+	ReportJSON  = "json"
+)
+
+// Report counts found users per value of a field. For browsers and
+// browser.* fields a user is counted once for every distinct value among
+// their browsers, so the counts may add up to more than Users.
+//
+// An approximate report keeps a count-min sketch and a HyperLogLog instead
+// of a map of all the values, its counts may be overestimated. The
+// browser_count histogram is small and always exact.
+type Report struct {
+	Name        string
+	Top         int // rows in the result, 0 for all
+	Approximate bool
+
+	field     field
+	histogram bool
+	users     int
+	counts    map[string]int
+	distinct  *HyperLogLog
+	hitters   *heavyHitters
+	keys      []string // distinct values of the current user
+}
+
+// capacity of heavy hitters for an approximate report without Top
+const approximateRows = 1000
+
+func NewReport(name string, top int, approximate bool) (*Report, error) {
+	r := &Report{Name: name, Top: top, Approximate: approximate, counts: map[string]int{}}
+	if name == HistogramReport {
+		r.histogram = true
+		r.Approximate = false
+		return r, nil
+	}
+	f, ok := fieldNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown report %q", name)
+	}
+	r.field = f
+	if approximate {
+		capacity := approximateRows
+		if top > 0 {
+			// the extra candidates let late heavy values in
+			capacity = top * 4
+		}
+		r.distinct = NewHyperLogLog()
+		r.hitters = newHeavyHitters(capacity)
+	}
+	return r, nil
+}
+
+// ParseReports makes reports from a comma separated list of names
+func ParseReports(spec string, top int, approximate bool) ([]*Report, error) {
+	reports := []*Report{}
+	for _, name := range strings.Split(spec, ",") {
+		r, err := NewReport(strings.ToLower(strings.TrimSpace(name)), top, approximate)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+func (r *Report) fields() fieldSet {
+	if r.histogram || r.field.isUserAgent() {
+		return fieldBrowsers.bit()
+	}
+	return r.field.bit()
+}
+
+func (r *Report) add(user *userRecord) {
+	r.users++
+	switch {
+	case r.histogram:
+		r.countString(strconv.Itoa(len(user.browsers)))
+	case r.field == fieldBrowsers:
+		for i, browser := range user.browsers {
+			if !containsBytes(user.browsers[:i], browser) {
+				r.countBytes(browser)
+			}
+		}
+	case r.field.isUserAgent():
+		r.keys = r.keys[:0]
+		for _, browser := range user.browsers {
+			key := browserValue(r.field, browser)
+			if !containsString(r.keys, key) {
+				r.keys = append(r.keys, key)
+				r.countString(key)
+			}
+		}
+	default:
+		r.countBytes(user.fieldValue(r.field))
+	}
+}
+
+func (r *Report) countBytes(key []byte) {
+	if r.Approximate {
+		r.distinct.Add(key)
+		r.hitters.addBytes(key)
+		return
+	}
+	r.counts[string(key)]++
+}
+
+func (r *Report) countString(key string) {
+	if r.Approximate {
+		r.distinct.AddString(key)
+		r.hitters.addString(key)
+		return
+	}
+	r.counts[key]++
+}
+
+func containsBytes(list [][]byte, b []byte) bool {
+	for _, item := range list {
+		if string(item) == string(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type ReportRow struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// ReportResult is a finished report. Rows go by count, the histogram goes
+// by the number of browsers.
+type ReportResult struct {
+	Name        string      `json:"report"`
+	Users       int         `json:"users"`
+	Distinct    int         `json:"distinct"`
+	Approximate bool        `json:"approximate,omitempty"`
+	Rows        []ReportRow `json:"rows"`
+}
+
+func (r *Report) Result() ReportResult {
+	res := ReportResult{Name: r.Name, Users: r.users, Approximate: r.Approximate, Rows: []ReportRow{}}
+	counts := r.counts
+	res.Distinct = len(counts)
+	if r.Approximate {
+		counts = r.hitters.counts()
+		res.Distinct = r.distinct.Count()
+	}
+	for key, count := range counts {
+		res.Rows = append(res.Rows, ReportRow{key, count})
+	}
+
+	if r.histogram {
+		sort.Slice(res.Rows, func(i, j int) bool {
+			a, _ := strconv.Atoi(res.Rows[i].Key)
+			b, _ := strconv.Atoi(res.Rows[j].Key)
+			return a < b
+		})
+		return res
+	}
+	sort.Slice(res.Rows, func(i, j int) bool {
+		a, b := res.Rows[i], res.Rows[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Key < b.Key
+	})
+	if r.Top > 0 && len(res.Rows) > r.Top {
+		res.Rows = res.Rows[:r.Top]
+	}
+	return res
+}
+
+// WriteReports writes results as aligned tables, csv with a
+// report,key,count header or a json line per report.
+func WriteReports(out io.Writer, format string, results []ReportResult) error {
+	switch format {
+	case ReportTable:
+		return writeReportTables(out, results)
+	case ReportCSV:
+		w := csv.NewWriter(out)
+		w.Write([]string{"report", "key", "count"})
+		for _, res := range results {
+			for _, row := range res.Rows {
+				w.Write([]string{res.Name, row.Key, strconv.Itoa(row.Count)})
+			}
+		}
+		w.Flush()
+		return w.Error()
+	case ReportJSON:
+		enc := json.NewEncoder(out)
+		for _, res := range results {
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+func writeReportTables(out io.Writer, results []ReportResult) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for i, res := range results {
+		about := "~"
+		if !res.Approximate {
+			about = ""
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s: %s%d users, %s%d distinct\n", res.Name, about, res.Users, about, res.Distinct)
+		for _, row := range res.Rows {
+			fmt.Fprintf(w, "%s\t%s%d\n", row.Key, about, row.Count)
+		}
+	}
+	return w.Flush()
+}
+
+// ReportSink passes results to another sink and computes reports over the
+// found users, the reports are written to out after the sink is done.
+type ReportSink struct {
+	sink    resultSink
+	out     io.Writer
+	format  string
+	reports []*Report
+}
+
+func NewReportSink(sink resultSink, out io.Writer, format string, reports []*Report) (*ReportSink, error) {
+	switch format {
+	case ReportTable, ReportCSV, ReportJSON:
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+	return &ReportSink{sink: sink, out: out, format: format, reports: reports}, nil
+}
+
+func (s *ReportSink) fields() fieldSet {
+	fields := sinkFields(s.sink)
+	for _, r := range s.reports {
+		fields |= r.fields()
+	}
+	return fields
+}
+
+func (s *ReportSink) Found(i int, user *userRecord) error {
+	for _, r := range s.reports {
+		r.add(user)
+	}
+	return s.sink.Found(i, user)
+}
+
+func (s *ReportSink) Done(uniqueBrowsers int) error {
+	if err := s.sink.Done(uniqueBrowsers); err != nil {
+		return err
+	}
+	results := make([]ReportResult, len(s.reports))
+	for i, r := range s.reports {
+		results[i] = r.Result()
+	}
+	return WriteReports(s.out, s.format, results)
+}
+
+// DiscardSink drops the found users, for reports only output
+type DiscardSink struct{}
+
+func (DiscardSink) Found(i int, user *userRecord) error { return nil }
+func (DiscardSink) Done(uniqueBrowsers int) error       { return nil }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reportReference counts users matching q per field value with encoding/json
+func reportReference(t *testing.T, q *Query, value func(u *UserType) []string) map[string]int {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		user := &UserType{}
+		if err := json.Unmarshal(scanner.Bytes(), user); err != nil {
+			t.Fatal(err)
+		}
+		if !q.Match(user) {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, v := range value(user) {
+			if !seen[v] {
+				seen[v] = true
+				counts[v]++
+			}
+		}
+	}
+	return counts
+}
+
+func runReports(t *testing.T, q *Query, spec string, top int, approximate bool) []ReportResult {
+	reports, err := ParseReports(spec, top, approximate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewReportSink(DiscardSink{}, new(bytes.Buffer), ReportJSON, reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SearchFile(filePath, q, sink); err != nil {
+		t.Fatal(err)
+	}
+	results := []ReportResult{}
+	for _, r := range reports {
+		results = append(results, r.Result())
+	}
+	return results
+}
+
+func TestReports(t *testing.T) {
+	q := MustCompileQuery(`browser.device = "mobile"`)
+	results := runReports(t, q, "country,browsers,browser.family,browser_count", 0, false)
+
+	expected := []map[string]int{
+		reportReference(t, q, func(u *UserType) []string { return []string{u.Country} }),
+		reportReference(t, q, func(u *UserType) []string { return u.Browsers }),
+		reportReference(t, q, func(u *UserType) []string {
+			families := []string{}
+			for _, b := range u.Browsers {
+				families = append(families, ParseUserAgent(b).Family)
+			}
+			return families
+		}),
+		reportReference(t, q, func(u *UserType) []string { return []string{fmt.Sprint(len(u.Browsers))} }),
+	}
+	for i, res := range results {
+		got := map[string]int{}
+		for _, row := range res.Rows {
+			got[row.Key] = row.Count
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expected[i]) || res.Distinct != len(expected[i]) {
+			t.Errorf("%s: got %v, expected %v", res.Name, got, expected[i])
+		}
+	}
+	if results[0].Users == 0 || results[0].Users != results[3].Rows[0].Count {
+		t.Errorf("unexpected number of users %d", results[0].Users)
+	}
+
+	top := runReports(t, q, "browser.family", 3, false)[0]
+	if len(top.Rows) != 3 || top.Rows[0].Count < top.Rows[1].Count || top.Rows[1].Count < top.Rows[2].Count {
+		t.Errorf("bad top rows %v", top.Rows)
+	}
+
+	if _, err := ParseReports("country,salary", 0, false); err == nil {
+		t.Errorf("expected error for unknown report")
+	}
+}
+
+func TestReportsApproximate(t *testing.T) {
+	exact := runReports(t, defaultQuery, "company,browsers", 5, false)
+	approx := runReports(t, defaultQuery, "company,browsers", 5, true)
+	for i := range exact {
+		if !approx[i].Approximate {
+			t.Errorf("%s: not approximate", approx[i].Name)
+		}
+		// the data is small, so the sketches have no collisions
+		if fmt.Sprint(approx[i].Rows) != fmt.Sprint(exact[i].Rows) {
+			t.Errorf("%s: got %v, expected %v", approx[i].Name, approx[i].Rows, exact[i].Rows)
+		}
+		if diff := math.Abs(float64(approx[i].Distinct - exact[i].Distinct)); diff > 0.05*float64(exact[i].Distinct) {
+			t.Errorf("%s: distinct %d, expected about %d", approx[i].Name, approx[i].Distinct, exact[i].Distinct)
+		}
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		hll := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			hll.AddString(fmt.Sprint("user", i))
+			hll.Add([]byte(fmt.Sprint("user", i)))
+		}
+		if got := hll.Count(); math.Abs(float64(got-n)) > 0.05*float64(n) {
+			t.Errorf("%d distinct values estimated as %d", n, got)
+		}
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	h := newHeavyHitters(8)
+	// a few heavy values among many light ones, the heavy ones come last
+	for i := 0; i < 20000; i++ {
+		h.addString(fmt.Sprint("light", i))
+	}
+	for i := 0; i < 3000; i++ {
+		h.addString(fmt.Sprint("heavy", i%3))
+	}
+	counts := h.counts()
+	if len(counts) != 8 {
+		t.Errorf("expected 8 candidates, got %d", len(counts))
+	}
+	for i := 0; i < 3; i++ {
+		count, ok := counts[fmt.Sprint("heavy", i)]
+		if !ok || count < 1000 {
+			t.Errorf("heavy%d: count %d, found %v", i, count, ok)
+		}
+	}
+}
+
+func TestHeavyHittersEviction(t *testing.T) {
+	h := newHeavyHitters(2)
+	for _, key := range []string{"b", "a", "c", "a", "c"} {
+		h.addString(key)
+	}
+	// c is not let in with 1 like the candidates, with 2 it evicts b
+	if counts := h.counts(); !reflect.DeepEqual(counts, map[string]int{"a": 2, "c": 2}) {
+		t.Errorf("unexpected candidates %v", counts)
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	results := []ReportResult{
+		{Name: "country", Users: 3, Distinct: 2, Rows: []ReportRow{{"Kenya", 2}, {"Fiji, Oceania", 1}}},
+		{Name: "browser_count", Users: 3, Distinct: 1, Rows: []ReportRow{{"4", 3}}},
+	}
+
+	out := new(bytes.Buffer)
+	if err := WriteReports(out, ReportCSV, results); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"report", "key", "count"}, {"country", "Kenya", "2"}, {"country", "Fiji, Oceania", "1"}, {"browser_count", "4", "3"}}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("csv: got %v, expected %v", records, expected)
+	}
+
+	out.Reset()
+	if err := WriteReports(out, ReportJSON, results); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(out)
+	for _, res := range results {
+		got := ReportResult{}
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(res) {
+			t.Errorf("json: got %v, expected %v", got, res)
+		}
+	}
+
+	out.Reset()
+	if err := WriteReports(out, ReportTable, results); err != nil {
+		t.Fatal(err)
+	}
+	table := "country: 3 users, 2 distinct\nKenya          2\nFiji, Oceania  1\n\nbrowser_count: 3 users, 1 distinct\n4  3\n"
+	if out.String() != table {
+		t.Errorf("table: got\n%s\nexpected\n%s", out, table)
+	}
+
+	if err := WriteReports(out, "xml", results); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestRunCLIReports(t *testing.T) {
+	query := `browsers contains "MSIE"`
+	serial := new(bytes.Buffer)
+	if err := run([]string{"-q", query, "-report", "country,browser.os", "-report-format", "csv"}, serial); err != nil {
+		t.Fatal(err)
+	}
+	// the found users go first, then the reports
+	expected := new(bytes.Buffer)
+	FastSearchQuery(expected, MustCompileQuery(query))
+	if !strings.HasPrefix(serial.String(), expected.String()+"report,key,count\n") {
+		t.Errorf("unexpected output\n%s", serial)
+	}
+
+	for _, args := range [][]string{
+		{"-workers", "4"},
+		{"-index"},
+	} {
+		if args[0] == "-index" {
+			args = append(args, "-in", copyDataFile(t))
+		}
+		got := new(bytes.Buffer)
+		if err := run(append(args, "-q", query, "-report", "country,browser.os", "-report-format", "csv"), got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != serial.String() {
+			t.Errorf("%v: results not match\nGot:\n%v\nExpected:\n%v", args, got, serial)
+		}
+	}
+
+	got := new(bytes.Buffer)
+	if err := run([]string{"-format", "none", "-report", "browser_count", "-report-format", "json"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got.String(), `{"report":"browser_count"`) {
+		t.Errorf("unexpected output %s", got)
+	}
+
+	for _, args := range [][]string{
+		{"-report", "salary"},
+		{"-report", "country", "-report-format", "xml"},
+	} {
+		if err := run(args, new(bytes.Buffer)); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
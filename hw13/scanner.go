@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// userRecord is a user decoded by scanUser. Strings are slices of the
+// scanned line (or of scratch if they had escapes), so they are valid
+// only until the next scanUser call.
+type userRecord struct {
+	name     []byte
+	email    []byte
+	browsers [][]byte
+	company  []byte
+	country  []byte
+	job      []byte
+	phone    []byte
+
+	scratch []byte
+}
+
+type fieldSet uint8
+
+func (f field) bit() fieldSet {
+	return 1 << uint(f)
+}
+
+const allFields fieldSet = 1<<(fieldPhone+1) - 1
+
+// same as encoding/json
+const maxNestingDepth = 10000
+
+var errUnexpectedEnd = errors.New("unexpected end of JSON input")
+
+type scanError struct {
+	msg    string
+	offset int
+}
+
+func (e *scanError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.msg, e.offset)
+}
+
+// User returns a copy of the record that does not point into the line.
+func (r *userRecord) User() UserType {
+	u := UserType{
+		Name:    string(r.name),
+		Email:   string(r.email),
+		Company: string(r.company),
+		Country: string(r.country),
+		Job:     string(r.job),
+		Phone:   string(r.phone),
+	}
+	if r.browsers != nil {
+		u.Browsers = make([]string, len(r.browsers))
+		for i, b := range r.browsers {
+			u.Browsers[i] = string(b)
+		}
+	}
+	return u
+}
+
+func (r *userRecord) fieldValue(f field) []byte {
+	switch f {
+	case fieldName:
+		return r.name
+	case fieldEmail:
+		return r.email
+	case fieldCompany:
+		return r.company
+	case fieldCountry:
+		return r.country
+	case fieldJob:
+		return r.job
+	case fieldPhone:
+		return r.phone
+	}
+	return nil
+}
+
+func (r *userRecord) setField(f field, v []byte) {
+	switch f {
+	case fieldName:
+		r.name = v
+	case fieldEmail:
+		r.email = v
+	case fieldCompany:
+		r.company = v
+	case fieldCountry:
+		r.country = v
+	case fieldJob:
+		r.job = v
+	case fieldPhone:
+		r.phone = v
+	}
+}
+
+// fieldByKey matches keys case insensitively like encoding/json does
+func fieldByKey(key []byte) (field, bool) {
+	switch len(key) {
+	case 3:
+		if bytes.EqualFold(key, []byte("job")) {
+			return fieldJob, true
+		}
+	case 4:
+		if bytes.EqualFold(key, []byte("name")) {
+			return fieldName, true
+		}
+	case 5:
+		if bytes.EqualFold(key, []byte("email")) {
+			return fieldEmail, true
+		}
+		if bytes.EqualFold(key, []byte("phone")) {
+			return fieldPhone, true
+		}
+	case 7:
+		if bytes.EqualFold(key, []byte("company")) {
+			return fieldCompany, true
+		}
+		if bytes.EqualFold(key, []byte("country")) {
+			return fieldCountry, true
+		}
+	case 8:
+		if bytes.EqualFold(key, []byte("browsers")) {
+			return fieldBrowsers, true
+		}
+	}
+	// non ascii keys like "ſcore" could still fold to ascii ones
+	for _, c := range key {
+		if c >= utf8.RuneSelf {
+			for f, name := range fieldNameList {
+				if bytes.EqualFold(key, []byte(name)) {
+					return field(f), true
+				}
+			}
+			break
+		}
+	}
+	return 0, false
+}
+
+var fieldNameList = [...]string{
+	fieldName:     "name",
+	fieldEmail:    "email",
+	fieldBrowsers: "browsers",
+	fieldCompany:  "company",
+	fieldCountry:  "country",
+	fieldJob:      "job",
+	fieldPhone:    "phone",
+}
+
+type userScanner struct {
+	data  []byte
+	pos   int
+	depth int
+	rec   *userRecord
+}
+
+// scanUser decodes a json line into rec the way encoding/json would decode
+// it into UserType, but only the fields from want, other values are
+// validated and skipped.
+func scanUser(line []byte, rec *userRecord, want fieldSet) error {
+	for f := fieldName; f <= fieldPhone; f++ {
+		if want&f.bit() == 0 {
+			continue
+		}
+		if f == fieldBrowsers {
+			rec.browsers = rec.browsers[:0]
+			continue
+		}
+		rec.setField(f, nil)
+	}
+	rec.scratch = rec.scratch[:0]
+
+	s := userScanner{data: line, rec: rec}
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return errUnexpectedEnd
+	}
+	switch s.data[s.pos] {
+	case '{':
+		if err := s.object(want); err != nil {
+			return err
+		}
+	case 'n':
+		if err := s.literal("null"); err != nil {
+			return err
+		}
+	default:
+		if err := s.skipValue(); err != nil {
+			return err
+		}
+		return s.errorf("cannot unmarshal non-object into UserType")
+	}
+	s.skipSpace()
+	if s.pos < len(s.data) {
+		return s.errorf("invalid character %q after top-level value", s.data[s.pos])
+	}
+	return nil
+}
+
+func (s *userScanner) errorf(format string, args ...interface{}) error {
+	return &scanError{fmt.Sprintf(format, args...), s.pos}
+}
+
+func (s *userScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *userScanner) expect(c byte) error {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return errUnexpectedEnd
+	}
+	if s.data[s.pos] != c {
+		return s.errorf("invalid character %q, expected %q", s.data[s.pos], c)
+	}
+	s.pos++
+	return nil
+}
+
+func (s *userScanner) enter() error {
+	s.depth++
+	if s.depth > maxNestingDepth {
+		return s.errorf("exceeded max depth")
+	}
+	return nil
+}
+
+func (s *userScanner) object(want fieldSet) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	s.pos++ // '{'
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == '}' {
+		s.pos++
+		s.depth--
+		return nil
+	}
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		if s.data[s.pos] != '"' {
+			return s.errorf("invalid character %q looking for object key", s.data[s.pos])
+		}
+		key, err := s.str(true)
+		if err != nil {
+			return err
+		}
+		if err := s.expect(':'); err != nil {
+			return err
+		}
+		s.skipSpace()
+
+		f, known := fieldByKey(key)
+		switch {
+		case !known || want&f.bit() == 0:
+			err = s.skipValue()
+		case f == fieldBrowsers:
+			err = s.browsers()
+		default:
+			err = s.stringField(f)
+		}
+		if err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			s.depth--
+			return nil
+		default:
+			return s.errorf("invalid character %q after object key:value pair", s.data[s.pos])
+		}
+	}
+}
+
+func (s *userScanner) stringField(f field) error {
+	if s.pos >= len(s.data) {
+		return errUnexpectedEnd
+	}
+	switch s.data[s.pos] {
+	case '"':
+		v, err := s.str(true)
+		if err != nil {
+			return err
+		}
+		s.rec.setField(f, v)
+		return nil
+	case 'n':
+		// null has no effect on a string
+		return s.literal("null")
+	}
+	if err := s.skipValue(); err != nil {
+		return err
+	}
+	return s.errorf("cannot unmarshal non-string into UserType.%s", fieldNameList[f])
+}
+
+func (s *userScanner) browsers() error {
+	if s.pos >= len(s.data) {
+		return errUnexpectedEnd
+	}
+	switch s.data[s.pos] {
+	case 'n':
+		s.rec.browsers = nil
+		return s.literal("null")
+	case '[':
+	default:
+		if err := s.skipValue(); err != nil {
+			return err
+		}
+		return s.errorf("cannot unmarshal non-array into UserType.browsers")
+	}
+
+	if err := s.enter(); err != nil {
+		return err
+	}
+	s.pos++ // '['
+	s.rec.browsers = s.rec.browsers[:0]
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == ']' {
+		s.pos++
+		s.depth--
+		return nil
+	}
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		switch s.data[s.pos] {
+		case '"':
+			v, err := s.str(true)
+			if err != nil {
+				return err
+			}
+			s.rec.browsers = append(s.rec.browsers, v)
+		case 'n':
+			if err := s.literal("null"); err != nil {
+				return err
+			}
+			s.rec.browsers = append(s.rec.browsers, nil)
+		default:
+			if err := s.skipValue(); err != nil {
+				return err
+			}
+			return s.errorf("cannot unmarshal non-string into UserType.browsers")
+		}
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			s.depth--
+			return nil
+		default:
+			return s.errorf("invalid character %q after array element", s.data[s.pos])
+		}
+	}
+}
+
+// str reads a string starting at '"'. If decode is false the string is
+// only validated and nil is returned.
+func (s *userScanner) str(decode bool) ([]byte, error) {
+	s.pos++ // '"'
+	start := s.pos
+	simple := true
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case c == '"':
+			v := s.data[start:s.pos]
+			s.pos++
+			if !decode {
+				return nil, nil
+			}
+			if simple {
+				return v, nil
+			}
+			return s.unquote(v), nil
+		case c == '\\':
+			simple = false
+			s.pos++
+			if s.pos >= len(s.data) {
+				return nil, errUnexpectedEnd
+			}
+			switch s.data[s.pos] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				s.pos++
+			case 'u':
+				s.pos++
+				for i := 0; i < 4; i++ {
+					if s.pos >= len(s.data) {
+						return nil, errUnexpectedEnd
+					}
+					if !isHex(s.data[s.pos]) {
+						return nil, s.errorf("invalid character %q in \\u hexadecimal character escape", s.data[s.pos])
+					}
+					s.pos++
+				}
+			default:
+				return nil, s.errorf("invalid character %q in string escape code", s.data[s.pos])
+			}
+		case c < 0x20:
+			return nil, s.errorf("invalid character %q in string literal", c)
+		case c >= utf8.RuneSelf:
+			if simple {
+				r, size := utf8.DecodeRune(s.data[s.pos:])
+				if r == utf8.RuneError && size == 1 {
+					simple = false
+				}
+				s.pos += size
+			} else {
+				s.pos++
+			}
+		default:
+			s.pos++
+		}
+	}
+	return nil, errUnexpectedEnd
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func getu4(s []byte) rune {
+	if len(s) < 6 || s[0] != '\\' || s[1] != 'u' {
+		return -1
+	}
+	var r rune
+	for _, c := range s[2:6] {
+		switch {
+		case '0' <= c && c <= '9':
+			c = c - '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return -1
+		}
+		r = r*16 + rune(c)
+	}
+	return r
+}
+
+// unquote decodes an already validated string body into scratch,
+// invalid utf8 and lone surrogates become U+FFFD as in encoding/json.
+func (s *userScanner) unquote(v []byte) []byte {
+	scratch := s.rec.scratch
+	start := len(scratch)
+	for r := 0; r < len(v); {
+		c := v[r]
+		switch {
+		case c == '\\':
+			r++
+			switch v[r] {
+			case 'b':
+				scratch = append(scratch, '\b')
+			case 'f':
+				scratch = append(scratch, '\f')
+			case 'n':
+				scratch = append(scratch, '\n')
+			case 'r':
+				scratch = append(scratch, '\r')
+			case 't':
+				scratch = append(scratch, '\t')
+			case 'u':
+				r--
+				rr := getu4(v[r:])
+				r += 6
+				if utf16.IsSurrogate(rr) {
+					rr1 := getu4(v[r:])
+					if dec := utf16.DecodeRune(rr, rr1); dec != unicode.ReplacementChar {
+						r += 6
+						scratch = utf8.AppendRune(scratch, dec)
+						continue
+					}
+					rr = unicode.ReplacementChar
+				}
+				scratch = utf8.AppendRune(scratch, rr)
+				continue
+			default: // '"', '\\', '/'
+				scratch = append(scratch, v[r])
+			}
+			r++
+		case c < utf8.RuneSelf:
+			scratch = append(scratch, c)
+			r++
+		default:
+			rr, size := utf8.DecodeRune(v[r:])
+			if rr == utf8.RuneError && size == 1 {
+				scratch = utf8.AppendRune(scratch, unicode.ReplacementChar)
+			} else {
+				scratch = append(scratch, v[r:r+size]...)
+			}
+			r += size
+		}
+	}
+	s.rec.scratch = scratch
+	return scratch[start:len(scratch):len(scratch)]
+}
+
+func (s *userScanner) literal(lit string) error {
+	for i := 0; i < len(lit); i++ {
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		if s.data[s.pos] != lit[i] {
+			return s.errorf("invalid character %q in literal %s", s.data[s.pos], lit)
+		}
+		s.pos++
+	}
+	return nil
+}
+
+func (s *userScanner) skipValue() error {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return errUnexpectedEnd
+	}
+	switch c := s.data[s.pos]; {
+	case c == '"':
+		_, err := s.str(false)
+		return err
+	case c == '{':
+		return s.skipObject()
+	case c == '[':
+		return s.skipArray()
+	case c == 't':
+		return s.literal("true")
+	case c == 'f':
+		return s.literal("false")
+	case c == 'n':
+		return s.literal("null")
+	case c == '-' || c >= '0' && c <= '9':
+		return s.number()
+	default:
+		return s.errorf("invalid character %q looking for beginning of value", c)
+	}
+}
+
+func (s *userScanner) skipObject() error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	s.pos++ // '{'
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == '}' {
+		s.pos++
+		s.depth--
+		return nil
+	}
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		if s.data[s.pos] != '"' {
+			return s.errorf("invalid character %q looking for object key", s.data[s.pos])
+		}
+		if _, err := s.str(false); err != nil {
+			return err
+		}
+		if err := s.expect(':'); err != nil {
+			return err
+		}
+		if err := s.skipValue(); err != nil {
+			return err
+		}
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			s.depth--
+			return nil
+		default:
+			return s.errorf("invalid character %q after object key:value pair", s.data[s.pos])
+		}
+	}
+}
+
+func (s *userScanner) skipArray() error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	s.pos++ // '['
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == ']' {
+		s.pos++
+		s.depth--
+		return nil
+	}
+	for {
+		if err := s.skipValue(); err != nil {
+			return err
+		}
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			s.depth--
+			return nil
+		default:
+			return s.errorf("invalid character %q after array element", s.data[s.pos])
+		}
+	}
+}
+
+func (s *userScanner) digits() int {
+	n := 0
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		s.pos++
+		n++
+	}
+	return n
+}
+
+// number validates -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?
+func (s *userScanner) number() error {
+	if s.data[s.pos] == '-' {
+		s.pos++
+	}
+	if s.pos >= len(s.data) {
+		return errUnexpectedEnd
+	}
+	switch c := s.data[s.pos]; {
+	case c == '0':
+		s.pos++
+	case c >= '1' && c <= '9':
+		s.digits()
+	default:
+		return s.errorf("invalid character %q in numeric literal", c)
+	}
+	if s.pos < len(s.data) && s.data[s.pos] == '.' {
+		s.pos++
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		if s.digits() == 0 {
+			return s.errorf("invalid character %q after decimal point in numeric literal", s.data[s.pos])
+		}
+	}
+	if s.pos < len(s.data) && (s.data[s.pos] == 'e' || s.data[s.pos] == 'E') {
+		s.pos++
+		if s.pos < len(s.data) && (s.data[s.pos] == '+' || s.data[s.pos] == '-') {
+			s.pos++
+		}
+		if s.pos >= len(s.data) {
+			return errUnexpectedEnd
+		}
+		if s.digits() == 0 {
+			return s.errorf("invalid character %q in exponent of numeric literal", s.data[s.pos])
+		}
+	}
+	return nil
+}
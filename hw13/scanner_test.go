@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var malformedLines = []string{
+	``,
+	` `,
+	`{`,
+	`}`,
+	`[]`,
+	`"name"`,
+	`null`,
+	`nul`,
+	`{"name":"a"} x`,
+	`{"name":"a",}`,
+	`{"name" "a"}`,
+	`{"name":1}`,
+	`{"name":"a","name":null}`,
+	`{"NAME":"a","Browsers":["x"]}`,
+	`{"browsers":"x"}`,
+	`{"browsers":["x",1]}`,
+	`{"browsers":["x",null]}`,
+	`{"browsers":["x"],"browsers":null}`,
+	`{"browsers":[,]}`,
+	`{"email":"a@b\"c\\d\/e\nf"}`,
+	`{"email":"😀 \ud83d \ude00 \u12"}`,
+	"{\"name\":\"\xff\xfe\"}",
+	"{\"name\":\"a\tb\"}",
+	`{"skip":{"a":[1,-2.5e+3,true,false,null,{}]},"name":"a"}`,
+	`{"skip":01}`,
+	`{"skip":1.}`,
+	`{"skip":-}`,
+	`{"skip":1e}`,
+	`{"skip":[1 2]}`,
+	`{"skip":{"a" 1}}`,
+	`{"skip":tru}`,
+	`{"ſkip":1,"phone":"1"}`,
+	`{"browſers":["x"]}`,
+}
+
+func FuzzScanUser(f *testing.F) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer file.Close()
+	fscanner := bufio.NewScanner(file)
+	for i := 0; i < 20 && fscanner.Scan(); i++ {
+		f.Add(append([]byte{}, fscanner.Bytes()...))
+	}
+	for _, line := range malformedLines {
+		f.Add([]byte(line))
+	}
+
+	f.Fuzz(func(t *testing.T, line []byte) {
+		expected := UserType{}
+		expectedErr := json.Unmarshal(line, &expected)
+
+		rec := &userRecord{}
+		err := scanUser(line, rec, allFields)
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("%q: error mismatch\nGot: %v\nExpected: %v", line, err, expectedErr)
+		}
+		if err != nil {
+			return
+		}
+		got := rec.User()
+		if len(got.Browsers) == 0 && len(expected.Browsers) == 0 {
+			got.Browsers, expected.Browsers = nil, nil
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q: results not match\nGot: %#v\nExpected: %#v", line, got, expected)
+		}
+	})
+}
+
+func TestScanUserLazy(t *testing.T) {
+	line := []byte(`{"name":"Susan","email":"s@x.org","country":"Kenya","browsers":["a","b"]}`)
+	rec := &userRecord{}
+	if err := scanUser(line, rec, fieldCountry.bit()); err != nil {
+		t.Fatal(err)
+	}
+	if string(rec.country) != "Kenya" || rec.name != nil || rec.email != nil || len(rec.browsers) != 0 {
+		t.Errorf("unexpected fields decoded: %#v", rec.User())
+	}
+
+	// strings without escapes point into the line
+	if err := scanUser(line, rec, allFields); err != nil {
+		t.Fatal(err)
+	}
+	if &rec.browsers[1][0] != &line[len(line)-4] {
+		t.Errorf("browser is not a slice of the line")
+	}
+}
+
+func TestScanUserAllocs(t *testing.T) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fscanner := bufio.NewScanner(file)
+	fscanner.Scan()
+	line := fscanner.Bytes()
+
+	rec := &userRecord{}
+	scanUser(line, rec, allFields)
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := scanUser(line, rec, allFields); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
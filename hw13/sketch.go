@@ -0,0 +1,232 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/bits"
+)
+
+// sketches for the approximate reports, memory does not grow with the input
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// mix64 is the splitmix64 finalizer, fnv alone has weak high bits
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+func hashBytes(b []byte) uint64 {
+	h := uint64(fnvOffset64)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return mix64(h)
+}
+
+func hashString(s string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return mix64(h)
+}
+
+// 2^12 registers, the standard error is 1.04/sqrt(4096) = 1.6%
+const hllPrecision = 12
+
+// HyperLogLog estimates the number of distinct values
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *HyperLogLog) addHash(x uint64) {
+	idx := x >> (64 - hllPrecision)
+	// the marker bit bounds rho when the rest of x is zero
+	rho := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+func (h *HyperLogLog) Add(b []byte) {
+	h.addHash(hashBytes(b))
+}
+
+func (h *HyperLogLog) AddString(s string) {
+	h.addHash(hashString(s))
+}
+
+func (h *HyperLogLog) Count() int {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is better for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(estimate + 0.5)
+}
+
+// CountMinSketch estimates counts of values, never below the real count
+type CountMinSketch struct {
+	width  uint64
+	counts [][]uint32
+}
+
+func NewCountMinSketch(width, depth int) *CountMinSketch {
+	s := &CountMinSketch{width: uint64(width), counts: make([][]uint32, depth)}
+	for i := range s.counts {
+		s.counts[i] = make([]uint32, width)
+	}
+	return s
+}
+
+// addHash counts x and returns the new estimate of its count
+func (s *CountMinSketch) addHash(x uint64) int {
+	// row hashes are h1 + i*h2, see Kirsch and Mitzenmacher
+	h1, h2 := x&math.MaxUint32, x>>32|1
+	estimate := uint32(math.MaxUint32)
+	for i, row := range s.counts {
+		j := (h1 + uint64(i)*h2) % s.width
+		row[j]++
+		if row[j] < estimate {
+			estimate = row[j]
+		}
+	}
+	return int(estimate)
+}
+
+func (s *CountMinSketch) Add(b []byte) int {
+	return s.addHash(hashBytes(b))
+}
+
+func (s *CountMinSketch) AddString(str string) int {
+	return s.addHash(hashString(str))
+}
+
+// heavyHitters keeps the values with the largest estimated counts,
+// at most capacity of them
+type heavyHitters struct {
+	sketch     *CountMinSketch
+	capacity   int
+	candidates map[string]*hitter
+	// byCount has the candidates with the smallest estimate first, so
+	// the one to evict is found in O(1) and replaced in O(log capacity)
+	byCount hitterHeap
+}
+
+type hitter struct {
+	key      string
+	estimate int
+	index    int // in byCount
+}
+
+func newHeavyHitters(capacity int) *heavyHitters {
+	return &heavyHitters{
+		sketch:     NewCountMinSketch(4096, 4),
+		capacity:   capacity,
+		candidates: map[string]*hitter{},
+	}
+}
+
+func (h *heavyHitters) addBytes(b []byte) {
+	estimate := h.sketch.Add(b)
+	if c, ok := h.candidates[string(b)]; ok {
+		h.update(c, estimate)
+		return
+	}
+	h.offer(string(b), estimate)
+}
+
+func (h *heavyHitters) addString(s string) {
+	estimate := h.sketch.AddString(s)
+	if c, ok := h.candidates[s]; ok {
+		h.update(c, estimate)
+		return
+	}
+	h.offer(s, estimate)
+}
+
+func (h *heavyHitters) update(c *hitter, estimate int) {
+	c.estimate = estimate
+	heap.Fix(&h.byCount, c.index)
+}
+
+func (h *heavyHitters) offer(key string, estimate int) {
+	if len(h.candidates) < h.capacity {
+		c := &hitter{key: key, estimate: estimate}
+		h.candidates[key] = c
+		heap.Push(&h.byCount, c)
+		return
+	}
+	if h.capacity == 0 || estimate <= h.byCount[0].estimate {
+		return
+	}
+	// the smallest candidate makes room, it is reused for the new one
+	c := h.byCount[0]
+	delete(h.candidates, c.key)
+	c.key, c.estimate = key, estimate
+	h.candidates[key] = c
+	heap.Fix(&h.byCount, 0)
+}
+
+// counts returns the estimates of the candidates
+func (h *heavyHitters) counts() map[string]int {
+	counts := make(map[string]int, len(h.candidates))
+	for key, c := range h.candidates {
+		counts[key] = c.estimate
+	}
+	return counts
+}
+
+// hitterHeap is a container/heap of the candidates, of equal estimates
+// the greatest key is evicted first
+type hitterHeap []*hitter
+
+func (hh hitterHeap) Len() int { return len(hh) }
+
+func (hh hitterHeap) Less(i, j int) bool {
+	if hh[i].estimate != hh[j].estimate {
+		return hh[i].estimate < hh[j].estimate
+	}
+	return hh[i].key > hh[j].key
+}
+
+func (hh hitterHeap) Swap(i, j int) {
+	hh[i], hh[j] = hh[j], hh[i]
+	hh[i].index, hh[j].index = i, j
+}
+
+func (hh *hitterHeap) Push(x interface{}) {
+	c := x.(*hitter)
+	c.index = len(*hh)
+	*hh = append(*hh, c)
+}
+
+func (hh *hitterHeap) Pop() interface{} {
+	old := *hh
+	c := old[len(old)-1]
+	*hh = old[:len(old)-1]
+	return c
+}
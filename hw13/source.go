@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// resultSink receives search results. user is the record the scanner
+// reuses, so it is valid only during the call, and only its name and email
+// are decoded unless the sink is a fieldSink. TextSink, JSONSink and the
+// sinks wrapping them are the ones to use.
+type resultSink interface {
+	Found(i int, user *userRecord) error
+	Done(uniqueBrowsers int) error
+}
+
+// fieldSink is a resultSink that needs more fields of found users
+type fieldSink interface {
+	resultSink
+	fields() fieldSet
+}
+
+// sinkFields returns the fields to decode for found users
+func sinkFields(sink resultSink) fieldSet {
+	if s, ok := sink.(fieldSink); ok {
+		return outputFields | s.fields()
+	}
+	return outputFields
+}
+
+// TextSink writes results in the FastSearch format
+type TextSink struct {
+	out     io.Writer
+	started bool
+}
+
+func NewTextSink(out io.Writer) *TextSink {
+	return &TextSink{out: out}
+}
+
+func (s *TextSink) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	_, err := fmt.Fprintln(s.out, "found users:")
+	return err
+}
+
+func (s *TextSink) Found(i int, user *userRecord) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	email := bytes.ReplaceAll(user.email, []byte("@"), []byte(" [at] "))
+	_, err := fmt.Fprintf(s.out, "[%d] %s <%s>\n", i, user.name, email)
+	return err
+}
+
+func (s *TextSink) Done(uniqueBrowsers int) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(s.out, "\nTotal unique browsers", uniqueBrowsers)
+	return err
+}
+
+// JSONSink writes a json line per found user and a final line with the total
+type JSONSink struct {
+	enc *json.Encoder
+}
+
+func NewJSONSink(out io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(out)}
+}
+
+type jsonFound struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type jsonTotal struct {
+	UniqueBrowsers int `json:"unique_browsers"`
+}
+
+func (s *JSONSink) Found(i int, user *userRecord) error {
+	return s.enc.Encode(jsonFound{i, string(user.name), string(user.email)})
+}
+
+func (s *JSONSink) Done(uniqueBrowsers int) error {
+	return s.enc.Encode(jsonTotal{uniqueBrowsers})
+}
+
+// Search runs q over json lines from r
+func Search(r io.Reader, q *Query, sink resultSink) error {
+	return SearchValidated(r, q, nil, sink)
+}
+
+// SearchValidated is Search with the lines checked by v,
+// see Validator for what happens to invalid ones
+func SearchValidated(r io.Reader, q *Query, v *Validator, sink resultSink) error {
+	seenBrowsers := map[string]bool{}
+	if _, err := searchLines(r, 0, q, v, sinkFields(sink), seenBrowsers, sink.Found); err != nil {
+		return err
+	}
+	return sink.Done(len(seenBrowsers))
+}
+
+// SearchFile runs q over the file opened with OpenSource
+func SearchFile(path string, q *Query, sink resultSink) error {
+	return SearchFileValidated(path, q, nil, sink)
+}
+
+// SearchFileValidated is SearchFile with the lines checked by v
+func SearchFileValidated(path string, q *Query, v *Validator, sink resultSink) error {
+	src, err := OpenSource(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return SearchValidated(src, q, v, sink)
+}
+
+const StdinPath = "-"
+
+// OpenSource opens path ("-" for stdin) with NewSourceReader
+func OpenSource(path string) (io.ReadCloser, error) {
+	if path == StdinPath {
+		return NewSourceReader(io.NopCloser(os.Stdin))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := NewSourceReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+type compression int
+
+const (
+	compressionNone compression = iota
+	compressionGzip
+	compressionZstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func detectCompression(head []byte) compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return compressionZstd
+	}
+	return compressionNone
+}
+
+// NewSourceReader decompresses gzip or zstd streams detected by their magic
+// bytes, other streams are returned as is. Closing the result closes r.
+func NewSourceReader(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch detectCompression(head) {
+	case compressionGzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &sourceReader{Reader: zr, closers: []io.Closer{zr, r}}, nil
+	case compressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &sourceReader{Reader: zr, closers: []io.Closer{zstdCloser{zr}, r}}, nil
+	}
+	return &sourceReader{Reader: br, closers: []io.Closer{r}}, nil
+}
+
+type sourceReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *sourceReader) Close() error {
+	var firstErr error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type zstdCloser struct {
+	d *zstd.Decoder
+}
+
+func (z zstdCloser) Close() error {
+	z.d.Close()
+	return nil
+}
+
+// writeFileAtomic writes the file at path with write through a temporary
+// file in the same directory, synced and renamed over path, so that a
+// reader never sees a half written file, also after a crash
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		// CreateTemp makes the file private
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
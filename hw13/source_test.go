@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressedCopies(t *testing.T) []string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	gzBuf := new(bytes.Buffer)
+	gz := gzip.NewWriter(gzBuf)
+	gz.Write(data)
+	gz.Close()
+
+	zstdBuf := new(bytes.Buffer)
+	zw, err := zstd.NewWriter(zstdBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(data)
+	zw.Close()
+
+	paths := []string{filepath.Join(dir, "users.txt.gz"), filepath.Join(dir, "users.txt.zst")}
+	ioutil.WriteFile(paths[0], gzBuf.Bytes(), 0644)
+	ioutil.WriteFile(paths[1], zstdBuf.Bytes(), 0644)
+	return paths
+}
+
+func TestSearchCompressed(t *testing.T) {
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+
+	for _, path := range compressedCopies(t) {
+		got := new(bytes.Buffer)
+		if err := SearchFile(path, defaultQuery, NewTextSink(got)); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", path, got, expected)
+		}
+	}
+}
+
+func TestSearchBadLine(t *testing.T) {
+	input := `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}
+{"browsers":["MSIE 8.0",
+{"browsers":["Android 2.0","MSIE 6.0"],"email":"g@h.i","name":"C"}`
+
+	out := new(bytes.Buffer)
+	err := Search(strings.NewReader(input), defaultQuery, NewTextSink(out))
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("expected error in line 2, got %#v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "users.txt")
+	ioutil.WriteFile(path, []byte(input), 0644)
+	err = ParallelSearchFile(path, defaultQuery, 3, NewTextSink(out))
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("parallel: expected error in line 2, got %#v", err)
+	}
+
+	if err := SearchFile("./data/no_such_file.txt", defaultQuery, NewTextSink(out)); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	input := `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}`
+	out := new(bytes.Buffer)
+	if err := Search(strings.NewReader(input), defaultQuery, NewJSONSink(out)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":0,"name":"A","email":"a@b.c"}` + "\n" + `{"unique_browsers":2}` + "\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+	}
+}
+
+func TestRunCLI(t *testing.T) {
+	query := `country = "Kenya" OR browsers ~ "MSIE [56]\\."`
+	expected := new(bytes.Buffer)
+	if err := FastSearchQuery(expected, MustCompileQuery(query)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"-q", query},
+		{"-q", query, "-workers", "0"},
+		{"-q", query, "-in", compressedCopies(t)[1], "-workers", "4"},
+		{"-q", query, "-unique", "browsers"},
+	} {
+		got := new(bytes.Buffer)
+		if err := run(args, got); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("%v: results not match\nGot:\n%v\nExpected:\n%v", args, got, expected)
+		}
+	}
+
+	got := new(bytes.Buffer)
+	if err := run([]string{"-format", "json"}, got); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(got.String()), "\n")
+	total := jsonTotal{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &total); err != nil || total.UniqueBrowsers == 0 {
+		t.Errorf("bad json total %q: %v", lines[len(lines)-1], err)
+	}
+
+	for _, args := range [][]string{
+		{"-q", "country ="},
+		{"-format", "xml"},
+		{"-unique", "country"},
+		{"-in", "./data/no_such_file.txt"},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestRunCLIPartialOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	content := `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}` + "\nbad\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []string{"1", "4"} {
+		out := new(bytes.Buffer)
+		err := run([]string{"-in", path, "-workers", workers}, out)
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%s workers: expected an error on line 2, got %v", workers, err)
+		}
+		if out.String() != "found users:\n[0] A <a [at] b.c>\n" {
+			t.Errorf("%s workers: expected the user before the error, got %q", workers, out)
+		}
+	}
+}
+
+func TestRunCLIStdin(t *testing.T) {
+	gzPath := compressedCopies(t)[0]
+	file, err := os.Open(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		io.Copy(w, file)
+		w.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+	got := new(bytes.Buffer)
+	if err := run([]string{"-in", "-", "-workers", "4"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "first")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("disk full")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "half")
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("expected the write error, got %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	st, _ := os.Stat(path)
+	if string(data) != "first" || st.Mode().Perm() != 0644 {
+		t.Errorf("unexpected file %q %v", data, st.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files are left: %v", entries)
+	}
+}
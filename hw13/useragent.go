@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// UserAgent is what ParseUserAgent could make of a browsers string.
+// Empty fields mean unknown.
+type UserAgent struct {
+	Family    string // IE, Chrome, Firefox, Safari, Opera, Android Browser, Googlebot...
+	Version   string
+	OS        string // Windows, Android, iOS, macOS, Linux...
+	OSVersion string
+	Device    string // desktop, mobile, tablet, bot or other
+
+	familyVersion string
+}
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceOther   = "other"
+)
+
+type uaFamilyRule struct {
+	marker  string // substring identifying the browser
+	family  string
+	version string // the version follows this marker, marker itself if empty
+}
+
+// order matters: Chrome user agents mention Safari, Opera ones mention MSIE etc
+var uaFamilyRules = []uaFamilyRule{
+	{"OPR/", "Opera", ""},
+	{"Opera Mini/", "Opera Mini", ""},
+	{"Opera/9.80", "Opera", "Version/"},
+	{"Opera/", "Opera", ""},
+	{"Opera ", "Opera", ""},
+	{"Edge/", "Edge", ""},
+	{"SamsungBrowser/", "Samsung Browser", ""},
+	{"IEMobile/", "IE Mobile", ""},
+	{"IEMobile ", "IE Mobile", ""},
+	{"MSIE ", "IE", ""},
+	{"Trident/7.0", "IE", "rv:"},
+	{"SeaMonkey/", "SeaMonkey", ""},
+	{"Firefox/", "Firefox", ""},
+	{"CriOS/", "Chrome", ""},
+	{"Chromium/", "Chromium", ""},
+	{"Chrome/", "Chrome", ""},
+	{"Iceweasel/", "Firefox", ""},
+	{"Minefield/", "Firefox", ""},
+	{"Shiretoko/", "Firefox", ""},
+	{"Iceape/", "SeaMonkey", ""},
+	{"Galeon/", "Galeon", ""},
+	{"Epiphany/", "Epiphany", ""},
+	{"Arora/", "Arora", ""},
+	{"QupZilla/", "QupZilla", ""},
+	{"OmniWeb/v", "OmniWeb", ""},
+	{"Silk/", "Silk", ""},
+	{"GSA/", "Google App", ""},
+	{"NokiaBrowser/", "Nokia Browser", ""},
+	{"BrowserNG/", "Nokia Browser", ""},
+	{"wOSBrowser/", "webOS Browser", ""},
+	{"webOSBrowser/", "webOS Browser", ""},
+	{"NetPositive/", "NetPositive", ""},
+	{"Dillo", "Dillo", "Dillo "},
+	{"Konqueror/", "Konqueror", ""},
+	{"Midori/", "Midori", ""},
+	{"NetFront/", "NetFront", ""},
+	{"UP.Browser/", "UP.Browser", ""},
+	{"Obigo/", "Obigo", ""},
+	{"ELinks", "ELinks", "("},
+	{"Links", "Links", "("},
+	{"Lynx/", "Lynx", ""},
+}
+
+var uaBotMarkers = []string{"bot", "crawler", "spider", "mediapartners-google", "feedfetcher", "ask jeeves", "slurp"}
+
+type uaOSRule struct {
+	marker  string
+	os      string
+	version string
+}
+
+var uaOSRules = []uaOSRule{
+	{"Windows Phone OS ", "Windows Phone", "Windows Phone OS "},
+	{"Windows Phone", "Windows Phone", "Windows Phone "},
+	{"Windows CE", "Windows CE", ""},
+	{"Windows NT ", "Windows", "Windows NT "},
+	{"Windows", "Windows", ""},
+	{"Win9", "Windows", ""},
+	{"iPhone", "iOS", " OS "},
+	{"iPad", "iOS", " OS "},
+	{"iPod", "iOS", " OS "},
+	{"Mac OS X", "macOS", "Mac OS X "},
+	{"Mac_PowerPC", "macOS", ""},
+	{"Macintosh", "macOS", ""},
+	{"CrOS", "Chrome OS", ""},
+	{"BlackBerry", "BlackBerry", ""},
+	{"BB10", "BlackBerry", ""},
+	{"Symbian", "Symbian", ""},
+	{"SymbOS", "Symbian", ""},
+	{"Series60", "Symbian", ""},
+	{"Series80", "Symbian", ""},
+	{"PalmOS", "Palm OS", ""},
+	{"PalmSource", "Palm OS", ""},
+	{"FreeBSD", "FreeBSD", ""},
+	{"NetBSD", "NetBSD", ""},
+	{"OpenBSD", "OpenBSD", ""},
+	{"SunOS", "SunOS", ""},
+	{"IRIX", "IRIX", ""},
+	{"BeOS", "BeOS", ""},
+	{"MeeGo", "MeeGo", ""},
+	{"webOS", "webOS", ""},
+	{"hpwOS", "webOS", ""},
+	{"OS/2", "OS/2", ""},
+	{"Darwin", "Darwin", ""},
+	{"Linux", "Linux", ""},
+	{"X11", "Linux", ""},
+}
+
+var windowsNTVersions = map[string]string{
+	"5.0":  "2000",
+	"5.1":  "XP",
+	"5.2":  "XP",
+	"6.0":  "Vista",
+	"6.1":  "7",
+	"6.2":  "8",
+	"6.3":  "8.1",
+	"10.0": "10",
+}
+
+var uaMobileMarkers = []string{"Mobile", "Mobi", "iPhone", "iPod", "MIDP", "Windows Phone", "Windows CE", "IEMobile",
+	"BlackBerry", "BB10", "Symbian", "PalmOS", "PalmSource", "Opera Mini", "UP.Browser", "j2me", "DoCoMo"}
+var uaTabletMarkers = []string{"iPad", "Tablet", "tablet", "TouchPad", "Kindle", "Silk/"}
+
+// readVersion reads a version like 10.0.1 or 4_3 (as 4.3) from the start of s
+func readVersion(s string) string {
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || s[end] == '_') {
+		end++
+	}
+	v := strings.TrimRight(s[:end], "._")
+	return strings.ReplaceAll(v, "_", ".")
+}
+
+func versionAfter(s, marker string) string {
+	i := strings.Index(s, marker)
+	if i < 0 {
+		return ""
+	}
+	return readVersion(s[i+len(marker):])
+}
+
+// androidPlatform finds "Android" as a platform name, not as a part of
+// a product token like "AndroidDownloadManager/5.1"
+func androidPlatform(s string) (version string, ok bool) {
+	for from := 0; ; {
+		i := strings.Index(s[from:], "Android")
+		if i < 0 {
+			return "", false
+		}
+		i += from
+		end := i + len("Android")
+		before := byte('(')
+		if i > 0 {
+			before = s[i-1]
+		}
+		after := byte(')')
+		if end < len(s) {
+			after = s[end]
+		}
+		if (before == '(' || before == ' ' || before == ';') && (after == ' ' || after == ';' || after == ')') {
+			return readVersion(strings.TrimLeft(s[end:], " ")), true
+		}
+		from = end
+	}
+}
+
+// productName is the first product of a user agent: "Java/1.6.0_13" -> Java
+func productName(s string) (name, version string) {
+	end := strings.IndexAny(s, "/(;")
+	if end < 0 {
+		end = len(s)
+	}
+	name = strings.TrimSpace(s[:end])
+	if end < len(s) && s[end] == '/' {
+		version = readVersion(s[end+1:])
+	}
+	if sp := strings.LastIndexByte(name, ' '); sp >= 0 && version == "" {
+		// "EmailWolf 1.00"
+		if v := readVersion(name[sp+1:]); v != "" && v == name[sp+1:] {
+			name, version = name[:sp], v
+		}
+	}
+	return name, version
+}
+
+// botName finds the bot product in a user agent like
+// "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+func botName(s string) (name, version string) {
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '(' || r == ')' || r == ';' }) {
+		if containsAny(strings.ToLower(part), uaBotMarkers) {
+			return productName(part)
+		}
+	}
+	return productName(s)
+}
+
+func containsAny(s string, markers []string) bool {
+	for _, m := range markers {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUserAgent extracts browser family, version, OS and device type from
+// a browsers string with a set of rules good enough for the users data.
+func ParseUserAgent(s string) UserAgent {
+	ua := UserAgent{}
+
+	lower := strings.ToLower(s)
+	isBot := containsAny(lower, uaBotMarkers)
+
+	for _, rule := range uaFamilyRules {
+		if isBot {
+			break
+		}
+		if !strings.Contains(s, rule.marker) {
+			continue
+		}
+		ua.Family = rule.family
+		versionMarker := rule.version
+		if versionMarker == "" {
+			versionMarker = rule.marker
+		}
+		ua.Version = versionAfter(s, versionMarker)
+		break
+	}
+
+	androidVersion, isAndroid := androidPlatform(s)
+	if ua.Family == "" && !isBot && strings.Contains(s, "Safari") {
+		if isAndroid && strings.Contains(s, "Version/") {
+			ua.Family = "Android Browser"
+		} else {
+			ua.Family = "Safari"
+		}
+		ua.Version = versionAfter(s, "Version/")
+	}
+	knownBrowser := ua.Family != ""
+	switch {
+	case isBot:
+		ua.Family, ua.Version = botName(s)
+	case ua.Family == "":
+		ua.Family, ua.Version = productName(s)
+	}
+
+	if isAndroid {
+		ua.OS, ua.OSVersion = "Android", androidVersion
+	} else {
+		for _, rule := range uaOSRules {
+			if !strings.Contains(s, rule.marker) {
+				continue
+			}
+			ua.OS = rule.os
+			if rule.version != "" {
+				ua.OSVersion = versionAfter(s, rule.version)
+			}
+			break
+		}
+		if ua.OS == "Windows" {
+			if name, ok := windowsNTVersions[ua.OSVersion]; ok {
+				ua.OSVersion = name
+			}
+		}
+	}
+
+	switch {
+	case isBot:
+		ua.Device = DeviceBot
+	case containsAny(s, uaTabletMarkers):
+		ua.Device = DeviceTablet
+	case containsAny(s, uaMobileMarkers):
+		ua.Device = DeviceMobile
+	case isAndroid:
+		// Android user agents without "Mobile" are tablets
+		ua.Device = DeviceTablet
+	case knownBrowser || ua.OS != "":
+		ua.Device = DeviceDesktop
+	default:
+		ua.Device = DeviceOther
+	}
+
+	ua.familyVersion = strings.TrimSpace(ua.Family + " " + ua.Version)
+	return ua
+}
+
+// Field returns the value of a browser.* query field
+func (ua *UserAgent) Field(f field) string {
+	switch f {
+	case fieldUAFamily:
+		return ua.Family
+	case fieldUAVersion:
+		return ua.Version
+	case fieldUAOS:
+		return ua.OS
+	case fieldUADevice:
+		return ua.Device
+	}
+	return ""
+}
+
+// browsers strings repeat a lot, so parsed user agents are cached
+const userAgentCacheSize = 100000
+
+var userAgentCache = struct {
+	sync.RWMutex
+	m map[string]*UserAgent
+}{m: map[string]*UserAgent{}}
+
+func userAgentOf(browser []byte) *UserAgent {
+	userAgentCache.RLock()
+	ua, ok := userAgentCache.m[string(browser)]
+	userAgentCache.RUnlock()
+	if ok {
+		return ua
+	}
+
+	parsed := ParseUserAgent(string(bytes.TrimSpace(browser)))
+	userAgentCache.Lock()
+	if len(userAgentCache.m) < userAgentCacheSize {
+		userAgentCache.m[string(browser)] = &parsed
+	}
+	userAgentCache.Unlock()
+	return &parsed
+}
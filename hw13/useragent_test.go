@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua       string
+		expected UserAgent
+	}{
+		{"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0; Trident/5.0)",
+			UserAgent{Family: "IE", Version: "7.0", OS: "Windows", OSVersion: "Vista", Device: DeviceDesktop}},
+		{"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{Family: "IE", Version: "11.0", OS: "Windows", OSVersion: "8.1", Device: DeviceDesktop}},
+		{"Mozilla/5.0 (Linux; U; Android 2.2; en-us; Nexus One Build/FRF91) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1",
+			UserAgent{Family: "Android Browser", Version: "4.0", OS: "Android", OSVersion: "2.2", Device: DeviceMobile}},
+		{"Mozilla/5.0 (Linux; Android 5.1.1; Nexus 7 Build/LMY47V) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/43.0.2357.78 Safari/537.36 OPR/30.0.1856.93524",
+			UserAgent{Family: "Opera", Version: "30.0.1856.93524", OS: "Android", OSVersion: "5.1.1", Device: DeviceTablet}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 10_0 like Mac OS X) AppleWebKit/602.1.38 (KHTML, like Gecko) Version/10.0 Mobile/14A300 Safari/602.1",
+			UserAgent{Family: "Safari", Version: "10.0", OS: "iOS", OSVersion: "10.0", Device: DeviceMobile}},
+		{"Mozilla/5.0 (Windows Phone 8.1; ARM; Trident/7.0; Touch; rv:11.0; IEMobile/11.0; NOKIA; Lumia 930) like Gecko",
+			UserAgent{Family: "IE Mobile", Version: "11.0", OS: "Windows Phone", OSVersion: "8.1", Device: DeviceMobile}},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:49.0) Gecko/20100101 Firefox/49.0",
+			UserAgent{Family: "Firefox", Version: "49.0", OS: "Linux", Device: DeviceDesktop}},
+		{"Opera/9.80 (X11; Linux i686) Presto/2.12.388 Version/12.16",
+			UserAgent{Family: "Opera", Version: "12.16", OS: "Linux", Device: DeviceDesktop}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{Family: "Googlebot", Version: "2.1", Device: DeviceBot}},
+		{"Java/1.6.0_13",
+			UserAgent{Family: "Java", Version: "1.6.0.13", Device: DeviceOther}},
+		// "Android" inside a product name is not the platform
+		{"AndroidDownloadManager/5.1",
+			UserAgent{Family: "AndroidDownloadManager", Version: "5.1", Device: DeviceOther}},
+		{"Links (2.1pre15; FreeBSD 5.3-RELEASE i386; 196x84)",
+			UserAgent{Family: "Links", Version: "2.1", OS: "FreeBSD", Device: DeviceDesktop}},
+	}
+	for _, c := range cases {
+		got := ParseUserAgent(c.ua)
+		got.familyVersion = ""
+		if got != c.expected {
+			t.Errorf("%s:\ngot      %+v\nexpected %+v", c.ua, got, c.expected)
+		}
+	}
+}
+
+func TestQueryUserAgent(t *testing.T) {
+	user := &UserType{
+		Browsers: []string{
+			"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0; Trident/5.0)",
+			"AndroidDownloadManager/5.1",
+		},
+	}
+	cases := []struct {
+		query string
+		match bool
+	}{
+		{DefaultQuery, true},
+		{`browser.family = "IE" AND browser.version ~ "^[67]\\."`, true},
+		{`browser.os = "Android"`, false},
+		{`browser.device = "desktop" AND NOT browser.device = "mobile"`, true},
+		{`browser.family contains "Android"`, true},
+	}
+	for _, c := range cases {
+		if got := MustCompileQuery(c.query).Match(user); got != c.match {
+			t.Errorf("%s: expected %v, got %v", c.query, c.match, got)
+		}
+	}
+
+	if _, err := CompileQuery(`browser.engine = "x"`); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+}
+
+func TestUniqueBy(t *testing.T) {
+	input := `{"browsers":["Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0)","Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 5.1)"],"name":"A"}
+{"browsers":["Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.1)","Mozilla/5.0 (X11; Linux x86_64; rv:49.0) Gecko/20100101 Firefox/49.0"],"name":"B"}`
+
+	cases := []struct {
+		uniqueBy string
+		total    string
+	}{
+		{"browsers", "Total unique browsers 3"},
+		{"browser.family", "Total unique browsers 1"},
+		{"browser.version", "Total unique browsers 2"},
+		{"browser.os", "Total unique browsers 1"},
+	}
+	for _, c := range cases {
+		q, err := MustCompileQuery(`browser.family = "IE"`).UniqueBy(c.uniqueBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		if err := Search(strings.NewReader(input), q, NewTextSink(out)); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), c.total) {
+			t.Errorf("%s: expected %q in\n%s", c.uniqueBy, c.total, out)
+		}
+	}
+
+	if _, err := defaultQuery.UniqueBy("country"); err == nil {
+		t.Errorf("expected error for a non browser field")
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Validation policies for invalid lines
+const (
+	ValidateSkip = "skip" // report and leave the line out of the search
+	ValidateFail = "fail" // report and stop the search with an error
+)
+
+// Types of SchemaField
+const (
+	TypeString     = "string"
+	TypeStringList = "[]string"
+)
+
+// FormatEmail is a SchemaField.Format for strings like local@domain.tld
+const FormatEmail = "email"
+
+// SchemaField declares a key of a users line. A required field must be
+// present, not null and, for strings, not empty.
+type SchemaField struct {
+	Name     string
+	Type     string
+	Required bool
+	Format   string
+}
+
+// Schema declares the fields of a users line, other keys are allowed
+type Schema []SchemaField
+
+// UserSchema is the schema of UserType, the fields used by the default
+// search are required
+var UserSchema = Schema{
+	{Name: "name", Type: TypeString, Required: true},
+	{Name: "email", Type: TypeString, Required: true, Format: FormatEmail},
+	{Name: "browsers", Type: TypeStringList, Required: true},
+	{Name: "company", Type: TypeString},
+	{Name: "country", Type: TypeString},
+	{Name: "job", Type: TypeString},
+	{Name: "phone", Type: TypeString},
+}
+
+// violationJSON is the Violation.Field of a line that is not valid json
+const violationJSON = "json"
+
+// Violation is a reason a line does not conform to the schema
+type Violation struct {
+	Line   int // from 1
+	Field  string
+	Reason string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("line %d: %s: %s", v.Line, v.Field, v.Reason)
+}
+
+// SchemaError is the error of an invalid line under ValidateFail,
+// it comes wrapped in a LineError
+type SchemaError struct {
+	Violations []Violation
+}
+
+func (e *SchemaError) Error() string {
+	reasons := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		reasons[i] = v.Field + ": " + v.Reason
+	}
+	return "schema violation: " + strings.Join(reasons, "; ")
+}
+
+// Validate checks a line against the schema, line is the line number for
+// the violations. Keys are matched case insensitively and the last of the
+// duplicates wins, as encoding/json decodes them.
+func (s Schema) Validate(line int, data []byte) []Violation {
+	if err := scanUser(data, &userRecord{}, 0); err != nil {
+		return []Violation{{line, violationJSON, err.Error()}}
+	}
+	values := map[string]json.RawMessage{}
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("{")) {
+		if err := s.values(data, values); err != nil {
+			return []Violation{{line, violationJSON, err.Error()}}
+		}
+	}
+
+	violations := []Violation{}
+	for _, f := range s {
+		value, ok := values[f.Name]
+		if !ok || string(value) == "null" {
+			if f.Required {
+				violations = append(violations, Violation{line, f.Name, "is missing"})
+			}
+			continue
+		}
+		if reason := f.check(value); reason != "" {
+			violations = append(violations, Violation{line, f.Name, reason})
+		}
+	}
+	return violations
+}
+
+// values collects the raw values of the schema fields of a json object
+func (s Schema) values(data []byte, values map[string]json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil { // '{'
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		value := json.RawMessage{}
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		for _, f := range s {
+			if strings.EqualFold(f.Name, key.(string)) {
+				values[f.Name] = value
+			}
+		}
+	}
+	return nil
+}
+
+// check returns why a non null value does not fit the field, "" if it does
+func (f SchemaField) check(value json.RawMessage) string {
+	switch f.Type {
+	case TypeString:
+		s := ""
+		if json.Unmarshal(value, &s) != nil {
+			return "must be a string, got " + jsonKind(value)
+		}
+		if f.Required && s == "" {
+			return "is empty"
+		}
+		// the value is not in the reason, violations are not redacted
+		if f.Format == FormatEmail && !validEmail(s) {
+			return "is not a valid email"
+		}
+	case TypeStringList:
+		list := []json.RawMessage{}
+		if json.Unmarshal(value, &list) != nil {
+			return "must be an array of strings, got " + jsonKind(value)
+		}
+		for i, item := range list {
+			if kind := jsonKind(item); kind != "string" {
+				return fmt.Sprintf("item %d must be a string, got %s", i, kind)
+			}
+		}
+	}
+	return ""
+}
+
+func jsonKind(value json.RawMessage) string {
+	switch value[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// validEmail checks for local@domain with a dot in the domain, no spaces
+// and a single '@'. It is not RFC 5322, which allows much more.
+func validEmail(s string) bool {
+	at := strings.IndexByte(s, '@')
+	if at <= 0 || strings.Count(s, "@") != 1 {
+		return false
+	}
+	if strings.ContainsAny(s, " \t\r\n<>()[],;:\"\\") {
+		return false
+	}
+	for _, label := range strings.Split(s[at+1:], ".") {
+		if label == "" {
+			return false
+		}
+	}
+	return strings.Contains(s[at+1:], ".")
+}
+
+// Validator checks lines before they are searched. Invalid lines are
+// passed to Report and skipped or failed according to Policy. Line
+// indices of the search stay the same when lines are skipped.
+type Validator struct {
+	Schema Schema
+	Policy string
+	// Report is called for every violation, it may be nil
+	Report func(v Violation) error
+
+	lines   int
+	invalid int
+	byField map[string]int
+	failed  bool
+}
+
+func NewValidator(schema Schema, policy string) (*Validator, error) {
+	switch policy {
+	case ValidateSkip, ValidateFail:
+	default:
+		return nil, fmt.Errorf("unknown validation policy %q", policy)
+	}
+	return &Validator{Schema: schema, Policy: policy, byField: map[string]int{}}, nil
+}
+
+// check validates a line, line counts from 1. It returns false for a line
+// to skip and a LineError with a SchemaError for a line to fail on.
+func (v *Validator) check(line int, data []byte) (bool, error) {
+	v.lines++
+	violations := v.Schema.Validate(line, data)
+	if len(violations) == 0 {
+		return true, nil
+	}
+	v.invalid++
+	for _, violation := range violations {
+		v.byField[violation.Field]++
+		if v.Report != nil {
+			if err := v.Report(violation); err != nil {
+				return false, err
+			}
+		}
+	}
+	if v.Policy == ValidateFail {
+		v.failed = true
+		return false, &LineError{line, &SchemaError{violations}}
+	}
+	return false, nil
+}
+
+// ValidationSummary counts the validated lines and the violations per field
+type ValidationSummary struct {
+	Lines      int            `json:"lines"`
+	Invalid    int            `json:"invalid"`
+	Policy     string         `json:"policy"`
+	Failed     bool           `json:"failed,omitempty"`
+	Violations map[string]int `json:"violations"`
+}
+
+func (v *Validator) Summary() ValidationSummary {
+	s := ValidationSummary{Lines: v.lines, Invalid: v.invalid, Policy: v.Policy, Failed: v.failed, Violations: map[string]int{}}
+	for f, n := range v.byField {
+		s.Violations[f] = n
+	}
+	return s
+}
+
+// WriteTo writes the summary as a few lines of text
+func (s ValidationSummary) WriteTo(out io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "validated %d lines, %d invalid", s.Lines, s.Invalid)
+	switch {
+	case s.Failed:
+		fmt.Fprint(buf, ", stopped at the first one\n")
+	case s.Invalid > 0:
+		fmt.Fprint(buf, ", skipped\n")
+	default:
+		fmt.Fprint(buf, "\n")
+	}
+	fields := []string{}
+	for f := range s.Violations {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	for _, f := range fields {
+		fmt.Fprintf(w, "  %s\t%d\n", f, s.Violations[f])
+	}
+	w.Flush()
+	n, err := out.Write(buf.Bytes())
+	return int64(n), err
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []Violation
+	}{
+		{`{"name":"A","email":"a@b.c","browsers":[]}`, nil},
+		{`{"NAME":"A","Email":"a@b.c","browsers":["x"],"other":1,"phone":null}`, nil},
+		{`{"name":"A","email":"a@b.c","browsers":["x"],"name":null}`, []Violation{{1, "name", "is missing"}}},
+		{`{"email":"a@b.c","browsers":["x"]}`, []Violation{{1, "name", "is missing"}}},
+		{`{"name":"","email":"a@b.c","browsers":["x"]}`, []Violation{{1, "name", "is empty"}}},
+		{`null`, []Violation{{1, "name", "is missing"}, {1, "email", "is missing"}, {1, "browsers", "is missing"}}},
+		{`{"name":1,"email":"a.b.c","browsers":"x","job":["x"]}`, []Violation{
+			{1, "name", "must be a string, got number"},
+			{1, "email", "is not a valid email"},
+			{1, "browsers", "must be an array of strings, got string"},
+			{1, "job", "must be a string, got array"},
+		}},
+		{`{"name":"A","email":"a@b.c","browsers":["x",null]}`, []Violation{{1, "browsers", "item 1 must be a string, got null"}}},
+		{`{"name":"A","email":"a@b.c","browsers":["x"]`, []Violation{{1, "json", "unexpected end of JSON input"}}},
+		{`[]`, []Violation{{1, "json", "cannot unmarshal non-object into UserType at offset 2"}}},
+	}
+	for _, c := range cases {
+		got := UserSchema.Validate(1, []byte(c.line))
+		if len(got) == 0 && len(c.expected) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s:\ngot      %v\nexpected %v", c.line, got, c.expected)
+		}
+	}
+
+	// the real data is valid
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if violations := UserSchema.Validate(i+1, []byte(line)); len(violations) > 0 {
+			t.Errorf("%v", violations)
+		}
+	}
+}
+
+func TestValidEmail(t *testing.T) {
+	for email, valid := range map[string]bool{
+		"a@b.c":            true,
+		"first.last@x.org": true,
+		"":                 false,
+		"@b.c":             false,
+		"a@b":              false,
+		"a@b.":             false,
+		"a@.b":             false,
+		"a@b@c.d":          false,
+		"a b@c.d":          false,
+		"A <a@b.c>":        false,
+	} {
+		if validEmail(email) != valid {
+			t.Errorf("validEmail(%q) != %v", email, valid)
+		}
+	}
+}
+
+const invalidUsers = `{"browsers":["MSIE 8.0","Android 4.0"],"email":"a@b.c","name":"A"}
+{"browsers":["MSIE 7.0","Android 1.0"],"email":"nope","name":"B"}
+{"browsers":["MSIE 8.0",
+{"browsers":["Android 2.0","MSIE 6.0"],"email":"g@h.i","name":"C"}`
+
+func TestSearchValidated(t *testing.T) {
+	v, err := NewValidator(UserSchema, ValidateSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := []string{}
+	v.Report = func(violation Violation) error {
+		reported = append(reported, violation.String())
+		return nil
+	}
+	out := new(bytes.Buffer)
+	if err := SearchValidated(strings.NewReader(invalidUsers), defaultQuery, v, NewTextSink(out)); err != nil {
+		t.Fatal(err)
+	}
+	// the skipped lines keep their indices and browsers
+	expected := "found users:\n[0] A <a [at] b.c>\n[3] C <g [at] h.i>\n\nTotal unique browsers 4\n"
+	if out.String() != expected {
+		t.Errorf("got:\n%v\nexpected:\n%v", out, expected)
+	}
+	if len(reported) != 2 || !strings.HasPrefix(reported[0], "line 2: email: is not a valid email") || !strings.HasPrefix(reported[1], "line 3: json: ") {
+		t.Errorf("unexpected violations %q", reported)
+	}
+	summary := v.Summary()
+	if summary.Lines != 4 || summary.Invalid != 2 || summary.Failed || !reflect.DeepEqual(summary.Violations, map[string]int{"email": 1, "json": 1}) {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	v, _ = NewValidator(UserSchema, ValidateFail)
+	err = SearchValidated(strings.NewReader(invalidUsers), defaultQuery, v, NewTextSink(ioutil.Discard))
+	lineErr, schemaErr := &LineError{}, &SchemaError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 || !errors.As(err, &schemaErr) || schemaErr.Violations[0].Field != "email" {
+		t.Errorf("expected a schema error in line 2, got %v", err)
+	}
+	if summary := v.Summary(); summary.Lines != 2 || summary.Invalid != 1 || !summary.Failed {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	if _, err := NewValidator(UserSchema, "ignore"); err == nil {
+		t.Errorf("expected error for an unknown policy")
+	}
+}
+
+func TestValidationSummary(t *testing.T) {
+	out := new(bytes.Buffer)
+	summary := ValidationSummary{Lines: 10, Invalid: 3, Policy: ValidateSkip, Violations: map[string]int{"json": 1, "email": 2}}
+	summary.WriteTo(out)
+	expected := "validated 10 lines, 3 invalid, skipped\n  email  2\n  json   1\n"
+	if out.String() != expected {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+}
+
+func TestRunCLIValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	ioutil.WriteFile(path, []byte(invalidUsers), 0644)
+	errOut := new(bytes.Buffer)
+	defer func(old io.Writer) { stderr = old }(stderr)
+	stderr = errOut
+
+	out := new(bytes.Buffer)
+	if err := run([]string{"-validate", ValidateSkip, "-workers", "4", "-in", path}, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[3] C") {
+		t.Errorf("unexpected output %q", out)
+	}
+	if !strings.HasPrefix(errOut.String(), "line 2: email: ") || !strings.Contains(errOut.String(), "validated 4 lines, 2 invalid, skipped\n") {
+		t.Errorf("unexpected diagnostics %q", errOut)
+	}
+
+	errOut.Reset()
+	if err := run([]string{"-validate", ValidateFail, "-in", path}, ioutil.Discard); err == nil {
+		t.Errorf("expected error")
+	}
+	if !strings.Contains(errOut.String(), "validated 2 lines, 1 invalid, stopped at the first one\n") {
+		t.Errorf("unexpected diagnostics %q", errOut)
+	}
+	for _, args := range [][]string{{"-validate", "maybe"}, {"-validate", ValidateSkip, "-index"}} {
+		if err := run(append(args, "-in", path), ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestRunCLIValidateRedact(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.txt")
+	ioutil.WriteFile(path, []byte(`{"browsers":["MSIE 8.0","Android 4.0"],"email":"secret.person-at-example.com","name":"Secret Person"}`), 0644)
+	redactPath := filepath.Join(dir, "redact.json")
+	ioutil.WriteFile(redactPath, []byte(`{"fields": {"email": "drop", "name": "drop"}}`), 0644)
+	errOut := new(bytes.Buffer)
+	defer func(old io.Writer) { stderr = old }(stderr)
+	stderr = errOut
+
+	for _, policy := range []string{ValidateSkip, ValidateFail} {
+		errOut.Reset()
+		run([]string{"-validate", policy, "-redact", redactPath, "-in", path}, ioutil.Discard)
+		if !strings.Contains(errOut.String(), "line 1: email: is not a valid email") {
+			t.Errorf("%s: unexpected diagnostics %q", policy, errOut)
+		}
+		if strings.Contains(errOut.String(), "secret") {
+			t.Errorf("%s: the email leaks to the diagnostics %q", policy, errOut)
+		}
+	}
+}
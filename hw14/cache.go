@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/list"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// CachePolicy says how FindUsers keeps the answers of the server. Answers
+// are kept per request and AccessToken, errors are not kept.
+type CachePolicy struct {
+	// TTL is how long an answer is used without asking the server. After
+	// it the answer is revalidated with If-None-Match if the server sent an
+	// ETag, a 304 keeps it for another TTL. 0 revalidates every time.
+	TTL time.Duration
+	// MaxEntries is the most answers kept, the least recently used ones
+	// go first. 0 for no limit.
+	MaxEntries int
+}
+
+// WithCache makes the client keep the answers according to p
+func WithCache(p CachePolicy) Option {
+	return func(o *clientOptions) {
+		o.cache = &p
+	}
+}
+
+type cacheEntry struct {
+	key     string
+	etag    string
+	cursor  string // Next-Cursor
+	body    []byte
+	expires time.Time
+}
+
+// responseCache is a LRU list of answers, safe for concurrent use
+type responseCache struct {
+	policy CachePolicy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, the most recently used first
+}
+
+func newResponseCache(p CachePolicy) *responseCache {
+	return &responseCache{policy: p, now: time.Now, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// cacheKey is the key of the request with the params, which Encode sorts
+func cacheKey(searchURL, accessToken string, params url.Values) string {
+	return accessToken + "\n" + searchURL + "?" + params.Encode()
+}
+
+// get returns the entry for key and if it may be used without asking the
+// server. Stale entries are returned only if they can be revalidated.
+// The entry must not be changed.
+func (c *responseCache) get(key string) (entry *cacheEntry, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry = el.Value.(*cacheEntry)
+	fresh = c.now().Before(entry.expires)
+	if !fresh && entry.etag == "" {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry, fresh
+}
+
+// put keeps the answer for key for a TTL
+func (c *responseCache) put(key, etag, cursor string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	entry := &cacheEntry{key: key, etag: etag, cursor: cursor, body: body, expires: c.now().Add(c.policy.TTL)}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.policy.MaxEntries > 0 && c.lru.Len() > c.policy.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// refresh keeps the entry for key for another TTL after a 304
+func (c *responseCache) refresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		// a new entry, the returned ones must not change
+		entry := *el.Value.(*cacheEntry)
+		entry.expires = c.now().Add(c.policy.TTL)
+		el.Value = &entry
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
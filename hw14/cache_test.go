@@ -0,0 +1,276 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hw4/server"
+)
+
+// cachedServer serves users with the server package, it counts requests
+// and remembers their If-None-Match
+type cachedServer struct {
+	mu          sync.Mutex
+	handler     http.Handler
+	requests    int
+	ifNoneMatch []string
+	notModified int
+}
+
+func newCachedServer(users server.Memory) *cachedServer {
+	return &cachedServer{handler: server.New(users, []string{"token", "other"})}
+}
+
+func (s *cachedServer) setUsers(users server.Memory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = server.New(users, []string{"token", "other"})
+}
+
+func (s *cachedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	s.ifNoneMatch = append(s.ifNoneMatch, r.Header.Get("If-None-Match"))
+	handler := s.handler
+	s.mu.Unlock()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code == http.StatusNotModified {
+		s.mu.Lock()
+		s.notModified++
+		s.mu.Unlock()
+	}
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+func (s *cachedServer) counts() (requests, notModified int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.notModified
+}
+
+// fakeClock is the time of a client cache, moved by the tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newCachedClient(t *testing.T, url string, p CachePolicy) (*SearchClient, *fakeClock) {
+	client := NewSearchClient(url, "token", WithCache(p))
+	clock := &fakeClock{now: time.Now()}
+	client.cache.now = func() time.Time { return clock.now }
+	return client, clock
+}
+
+func findUsers(t *testing.T, client *SearchClient, req SearchRequest) *SearchResponse {
+	resp, err := client.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCacheTTL(t *testing.T) {
+	users, err := server.LoadDataset(XMLDataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newCachedServer(users)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client, clock := newCachedClient(t, ts.URL, CachePolicy{TTL: time.Minute})
+
+	req := SearchRequest{Limit: 3, Query: "Lorem", Order: []SortKey{{"Age", true}}}
+	first := findUsers(t, client, req)
+	if second := findUsers(t, client, req); !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected %+v from the cache, got %+v", first, second)
+	}
+	if requests, _ := s.counts(); requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+
+	// other requests and tokens are kept apart
+	findUsers(t, client, SearchRequest{Limit: 3, Query: "Lorem"})
+	client.AccessToken = "other"
+	findUsers(t, client, req)
+	client.AccessToken = "token"
+	if requests, _ := s.counts(); requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	// stale answers are revalidated
+	clock.add(time.Minute)
+	if again := findUsers(t, client, req); !reflect.DeepEqual(first, again) {
+		t.Errorf("Expected %+v after 304, got %+v", first, again)
+	}
+	requests, notModified := s.counts()
+	if requests != 4 || notModified != 1 || s.ifNoneMatch[3] == "" {
+		t.Errorf("Expected a 304 for the 4th request, got %d requests %d 304 %q", requests, notModified, s.ifNoneMatch)
+	}
+	// and kept for another TTL
+	clock.add(time.Minute - time.Second)
+	findUsers(t, client, req)
+	if requests, _ := s.counts(); requests != 4 {
+		t.Errorf("Expected 4 requests, got %d", requests)
+	}
+
+	// changed answers replace the kept ones
+	s.setUsers(users[:1])
+	clock.add(time.Second)
+	if changed := findUsers(t, client, req); len(changed.Users) != 1 || changed.Users[0].Id != 0 {
+		t.Errorf("Expected the changed users, got %+v", changed)
+	}
+	if changed := findUsers(t, client, req); len(changed.Users) != 1 {
+		t.Errorf("Expected the changed users from the cache, got %+v", changed)
+	}
+	if requests, notModified := s.counts(); requests != 5 || notModified != 1 {
+		t.Errorf("Expected 5 requests and 1 304, got %d %d", requests, notModified)
+	}
+}
+
+// countingSearchServer is SearchServer that counts requests
+func countingSearchServer(requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		SearchServer(w, r)
+	}))
+}
+
+func TestCacheWithoutETag(t *testing.T) {
+	var requests int32
+	ts := countingSearchServer(&requests)
+	defer ts.Close()
+	client, clock := newCachedClient(t, ts.URL, CachePolicy{TTL: time.Minute})
+
+	req := SearchRequest{Limit: 2, OrderField: "Id", OrderBy: OrderByAsc}
+	findUsers(t, client, req)
+	findUsers(t, client, req)
+	clock.add(time.Minute)
+	// SearchServer sends no ETag, a stale answer is dropped
+	findUsers(t, client, req)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected 2 requests, got %d", n)
+	}
+
+	// errors are not kept
+	client.AccessToken = "Bad Token"
+	for i := 0; i < 2; i++ {
+		if _, err := client.FindUsers(req); err != ErrUnauthorized {
+			t.Errorf("Expected ErrUnauthorized, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("Expected 4 requests, got %d", n)
+	}
+}
+
+func TestCacheZeroTTL(t *testing.T) {
+	users, err := server.LoadDataset(XMLDataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newCachedServer(users)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client, _ := newCachedClient(t, ts.URL, CachePolicy{})
+
+	for i := 0; i < 3; i++ {
+		findUsers(t, client, SearchRequest{Limit: 5})
+	}
+	if requests, notModified := s.counts(); requests != 3 || notModified != 2 {
+		t.Errorf("Expected 3 requests with 2 304, got %d %d", requests, notModified)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	var requests int32
+	ts := countingSearchServer(&requests)
+	defer ts.Close()
+	client, _ := newCachedClient(t, ts.URL, CachePolicy{TTL: time.Hour, MaxEntries: 2})
+
+	a := SearchRequest{Limit: 1}
+	b := SearchRequest{Limit: 2}
+	c := SearchRequest{Limit: 3}
+	for i, req := range []SearchRequest{a, b, a, c, a, b} {
+		findUsers(t, client, req)
+		// a is used again before c comes, so b goes
+		expected := []int32{1, 2, 2, 3, 3, 4}[i]
+		if n := atomic.LoadInt32(&requests); n != expected {
+			t.Errorf("Request %d: expected %d requests, got %d", i, expected, n)
+		}
+	}
+	if n := client.cache.lru.Len(); n != 2 {
+		t.Errorf("Expected 2 entries, got %d", n)
+	}
+
+	// refreshing a gone entry does nothing
+	client.cache.refresh("gone")
+	if n := client.cache.lru.Len(); n != 2 {
+		t.Errorf("Expected 2 entries, got %d", n)
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	users, err := server.LoadDataset(XMLDataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(newCachedServer(users))
+	defer ts.Close()
+	client := NewSearchClient(ts.URL, "token", WithCache(CachePolicy{MaxEntries: 3}))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(limit int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := client.FindUsers(SearchRequest{Limit: limit})
+				if err != nil || len(resp.Users) != limit {
+					t.Errorf("Unexpected answer %v %v", resp, err)
+					return
+				}
+			}
+		}(i%5 + 1)
+	}
+	wg.Wait()
+}
+
+func TestCacheCursors(t *testing.T) {
+	users, err := server.LoadDataset(XMLDataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newCachedServer(users)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client, clock := newCachedClient(t, ts.URL, CachePolicy{TTL: time.Minute})
+
+	req := SearchRequest{Limit: 10, CursorPaging: true}
+	first := findUsers(t, client, req)
+	if first.NextCursor == "" || !first.NextPage {
+		t.Fatalf("Expected a cursor, got %+v", first)
+	}
+	// the cursor comes from the cache too, fresh or revalidated
+	for i := 0; i < 2; i++ {
+		if again := findUsers(t, client, req); !reflect.DeepEqual(first, again) {
+			t.Errorf("Expected %+v, got %+v", first, again)
+		}
+		clock.add(time.Minute)
+	}
+	if requests, notModified := s.counts(); requests != 2 || notModified != 1 {
+		t.Errorf("Expected 2 requests with 1 304, got %d %d", requests, notModified)
+	}
+}
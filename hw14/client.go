@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,10 +29,16 @@ type User struct {
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// NextCursor is the Cursor of the next page with CursorPaging,
+	// empty on the last one
+	NextCursor string
 }
 
 type SearchErrorResponse struct {
 	Error string
+	// Field is the bad order field or filter parameter
+	Field  string
+	Reason string
 }
 
 const (
@@ -39,7 +46,16 @@ const (
 	OrderByAsIs = 0
 	OrderByDesc = 1
 
+	// OrderFieldRelevance puts the users most relevant to Query first, the
+	// query is matched by words then. OrderBy is ignored with it, in Order
+	// Desc puts them last. Servers without a full-text index answer
+	// BadOrderFieldError.
+	OrderFieldRelevance = "relevance"
+
 	ErrorBadOrderField = `OrderField invalid`
+
+	// MaxPageSize is the most users FindUsers returns at once
+	MaxPageSize = 25
 )
 
 type SearchRequest struct {
@@ -48,6 +64,60 @@ type SearchRequest struct {
 	Query      string // подстрока в 1 из полей
 	OrderField string
 	OrderBy    int
+	// Order sorts by several fields instead of OrderField and OrderBy,
+	// the first key first. OrderField must be empty with it.
+	Order  []SortKey
+	Filter Filter
+	// CursorPaging pages with cursors, which unlike Offset stay right when
+	// users are added or removed between the pages. Cursor is the
+	// NextCursor of the previous page, empty for the first one, and it
+	// implies CursorPaging. Limit must not be 0 then.
+	CursorPaging bool
+	Cursor       string
+}
+
+// SortKey is a field to sort by, Id, Age, Name or OrderFieldRelevance
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Filter narrows the users found for the query, its zero value lets
+// every user through
+type Filter struct {
+	AgeMin int
+	AgeMax int    // 0 for no bound
+	Gender string // equal to Gender
+	About  string // substring of About
+}
+
+// addParams adds the parameters of the set fields only, so servers that
+// know no filters take the requests without them
+func (f Filter) addParams(params url.Values) {
+	if f.AgeMin > 0 {
+		params.Add("age_min", strconv.Itoa(f.AgeMin))
+	}
+	if f.AgeMax > 0 {
+		params.Add("age_max", strconv.Itoa(f.AgeMax))
+	}
+	if f.Gender != "" {
+		params.Add("gender", f.Gender)
+	}
+	if f.About != "" {
+		params.Add("about", f.About)
+	}
+}
+
+// orderParam is the order parameter, like -Age,Name
+func orderParam(order []SortKey) string {
+	keys := make([]string, len(order))
+	for i, key := range order {
+		keys[i] = key.Field
+		if key.Desc {
+			keys[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(keys, ",")
 }
 
 type SearchClient struct {
@@ -55,70 +125,216 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+
+	// nil for the package client with its 1 second timeout
+	httpClient *http.Client
+	retry      RetryPolicy
+	cache      *responseCache // nil without WithCache
+}
+
+// Option configures a SearchClient made by NewSearchClient
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	httpClient *http.Client
+	timeout    time.Duration
+	hasTimeout bool
+	transport  http.RoundTripper
+	retry      RetryPolicy
+	cache      *CachePolicy
+}
+
+// WithHTTPClient makes requests with a copy of c, the other options
+// change the copy only. A nil c is ignored.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *clientOptions) {
+		if c != nil {
+			o.httpClient = c
+		}
+	}
+}
+
+// WithTimeout limits every request to d, 0 for no limit but the context
+func WithTimeout(d time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = d
+		o.hasTimeout = true
+	}
+}
+
+// WithTransport makes requests with rt, like a transport with other
+// connection limits or a test one
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = rt
+	}
+}
+
+func NewSearchClient(searchURL, accessToken string, opts ...Option) *SearchClient {
+	o := &clientOptions{httpClient: client}
+	for _, opt := range opts {
+		opt(o)
+	}
+	c := *o.httpClient
+	if o.hasTimeout {
+		c.Timeout = o.timeout
+	}
+	if o.transport != nil {
+		c.Transport = o.transport
+	}
+	srv := &SearchClient{AccessToken: accessToken, URL: searchURL, httpClient: &c, retry: o.retry}
+	if o.cache != nil {
+		srv.cache = newResponseCache(*o.cache)
+	}
+	return srv
+}
+
+func (srv *SearchClient) do(req *http.Request) (*http.Response, error) {
+	c := srv.httpClient
+	if c == nil {
+		c = client
+	}
+	return c.Do(req)
+}
+
+// isTimeout reports if err is from the client timeout or the deadline of
+// the request context. A cancelled context is not a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	netErr := net.Error(nil)
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext is FindUsers that gives up when ctx is done
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
 		return nil, fmt.Errorf("limit must be > 0")
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	if req.Limit > MaxPageSize {
+		req.Limit = MaxPageSize
 	}
 	if req.Offset < 0 {
 		return nil, fmt.Errorf("offset must be > 0")
 	}
-
-	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
+	if len(req.Order) > 0 && req.OrderField != "" {
+		return nil, fmt.Errorf("OrderField and Order can't be used together")
+	}
+	req.CursorPaging = req.CursorPaging || req.Cursor != ""
+	if req.CursorPaging {
+		if req.Limit == 0 {
+			return nil, fmt.Errorf("limit must be > 0 with a cursor")
+		}
+		// the server says if there is a next page
+		searcherParams.Add("cursor", req.Cursor)
+	} else {
+		//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
+		req.Limit++
+	}
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if len(req.Order) > 0 {
+		searcherParams.Add("order", orderParam(req.Order))
+	}
+	req.Filter.addParams(searcherParams)
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	searcherReq, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad request: %w", err)
+	}
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
 
-	resp, err := client.Do(searcherReq)
+	var key string
+	var cached *cacheEntry
+	if srv.cache != nil {
+		key = cacheKey(srv.URL, srv.AccessToken, searcherParams)
+		entry, fresh := srv.cache.get(key)
+		if fresh {
+			return usersResponse(entry.body, req, entry.cursor)
+		}
+		if entry != nil {
+			cached = entry
+			searcherReq.Header.Set("If-None-Match", entry.etag)
+		}
+	}
+
+	status, header, body, err := srv.sendRetrying(ctx, searcherReq)
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+		// the timeout covers reading the body too
+		if isTimeout(err) {
+			return nil, &TimeoutError{searcherParams.Encode(), err}
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, fmt.Errorf("unknown error %w", err)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
-	case http.StatusBadRequest:
+	switch {
+	case status == http.StatusNotModified && cached != nil:
+		srv.cache.refresh(key)
+		return usersResponse(cached.body, req, cached.cursor)
+	case status == http.StatusNotModified:
+		// nothing was asked to revalidate, there is no body to unpack
+		return nil, fmt.Errorf("unexpected status %d", status)
+	case status == http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case status >= http.StatusInternalServerError:
+		return nil, &ServerError{status, body}
+	case status == http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
 			return nil, fmt.Errorf("cant unpack error json: %s", err)
 		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		switch errResp.Error {
+		case "ErrorBadOrderField":
+			field := req.OrderField
+			if errResp.Field != "" {
+				field = errResp.Field
+			}
+			return nil, &BadOrderFieldError{field}
+		case "ErrorBadFilter":
+			return nil, &BadFilterError{errResp.Field, errResp.Reason}
+		case "ErrorBadCursor":
+			return nil, &BadCursorError{errResp.Reason}
 		}
 		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
 
+	nextCursor := header.Get("Next-Cursor")
+	result, err := usersResponse(body, req, nextCursor)
+	if err == nil && srv.cache != nil && status == http.StatusOK {
+		srv.cache.put(key, header.Get("ETag"), nextCursor, body)
+	}
+	return result, err
+}
+
+// usersResponse unpacks the users for req as it was sent, with one more
+// user than asked for in req.Limit without CursorPaging
+func usersResponse(body []byte, req SearchRequest, nextCursor string) (*SearchResponse, error) {
 	data := []User{}
-	err = json.Unmarshal(body, &data)
+	err := json.Unmarshal(body, &data)
 	if err != nil {
 		return nil, fmt.Errorf("cant unpack result json: %s", err)
 	}
 
 	result := SearchResponse{}
-	if len(data) == req.Limit {
+	if req.CursorPaging {
+		result.Users = data
+		result.NextCursor = nextCursor
+		result.NextPage = nextCursor != ""
+	} else if len(data) == req.Limit {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
 	} else {
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -174,10 +176,16 @@ func TestBadSearchRequestParam(t *testing.T) {
 	cases := []SearchRequest{
 		{Limit: -1},
 		{Offset: -1},
+		{OrderField: "Age", Order: []SortKey{{Field: "Id"}}},
+		{CursorPaging: true},
+		{Cursor: "abc"},
 	}
 	errMessages := []string{
 		"limit must be > 0",
 		"offset must be > 0",
+		"OrderField and Order can't be used together",
+		"limit must be > 0 with a cursor",
+		"limit must be > 0 with a cursor",
 	}
 
 	client := new(SearchClient)
@@ -366,3 +374,173 @@ func TestOkResp(t *testing.T) {
 
 	ts.Close()
 }
+
+// blockingServer answers after the request is given up on
+func blockingServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+}
+
+func TestFindUsersContext(t *testing.T) {
+	ts := blockingServer()
+	defer ts.Close()
+	client := NewSearchClient(ts.URL, "", WithTimeout(0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := client.FindUsersContext(ctx, SearchRequest{})
+	if result != nil || err == nil || !strings.HasPrefix(err.Error(), "timeout") {
+		t.Errorf("Expected \"timeout\" error, got err: %#v result: %#v", err, result)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	result, err = client.FindUsersContext(ctx, SearchRequest{})
+	if result != nil || err == nil || !strings.HasPrefix(err.Error(), "unknown error") || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected \"unknown error\" error, got err: %#v result: %#v", err, result)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	ts := blockingServer()
+	defer ts.Close()
+	client := NewSearchClient(ts.URL, "", WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	result, err := client.FindUsers(SearchRequest{})
+	if result != nil || err == nil || !strings.HasPrefix(err.Error(), "timeout") {
+		t.Errorf("Expected \"timeout\" error, got err: %#v result: %#v", err, result)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("The client timeout is not used, the request took %s", elapsed)
+	}
+}
+
+func TestBodyErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, `[{"Id":`)
+		if r.Header.Get("AccessToken") == "slow body" {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	tokens := []string{"slow body", "short body"}
+	errMessages := []string{"timeout", "unknown error"}
+	for i := 0; i < len(tokens); i++ {
+		client := NewSearchClient(ts.URL, tokens[i], WithTimeout(50*time.Millisecond))
+		result, err := client.FindUsers(SearchRequest{})
+		if result != nil || err == nil || !strings.HasPrefix(err.Error(), errMessages[i]) {
+			t.Errorf("Expected \"%s\" error, got err: %#v result: %#v", errMessages[i], err, result)
+		}
+	}
+}
+
+// a 304 the client did not ask for is an error, with or without a cache
+func TestUnexpectedNotModified(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer ts.Close()
+
+	for _, opts := range [][]Option{nil, {WithCache(CachePolicy{TTL: time.Minute})}} {
+		client := NewSearchClient(ts.URL, "token", opts...)
+		result, err := client.FindUsers(SearchRequest{})
+		if result != nil || err == nil || err.Error() != "unexpected status 304" {
+			t.Errorf("Expected \"unexpected status 304\" error, got err: %#v result: %#v", err, result)
+		}
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeTransport answers every request with users and remembers the last request
+func fakeTransport(users string, last **http.Request) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		*last = r
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(users)),
+			Request:    r,
+		}, nil
+	})
+}
+
+func TestClientOptions(t *testing.T) {
+	var last *http.Request
+	client := NewSearchClient("http://search.test/users", "token", WithTransport(fakeTransport(`[{"Id":7}]`, &last)))
+	result, err := client.FindUsers(SearchRequest{Limit: 5, Query: "on", OrderField: "Age", OrderBy: OrderByDesc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, &SearchResponse{Users: []User{{Id: 7}}}) {
+		t.Errorf("Unexpected result %#v", result)
+	}
+	if last.Header.Get("AccessToken") != "token" || last.URL.Host != "search.test" ||
+		last.URL.RawQuery != "limit=6&offset=0&order_by=1&order_field=Age&query=on" {
+		t.Errorf("Unexpected request %s %#v", last.URL, last.Header)
+	}
+	if client.httpClient.Timeout != time.Second {
+		t.Errorf("Expected the default timeout, got %s", client.httpClient.Timeout)
+	}
+
+	injected := &http.Client{Transport: fakeTransport(`[]`, &last), Timeout: time.Minute}
+	client = NewSearchClient("http://search.test/users", "other", WithHTTPClient(injected), WithTimeout(time.Hour))
+	if _, err := client.FindUsers(SearchRequest{}); err != nil || last.Header.Get("AccessToken") != "other" {
+		t.Errorf("The injected client is not used, err: %#v", err)
+	}
+	if client.httpClient.Timeout != time.Hour || injected.Timeout != time.Minute {
+		t.Errorf("Expected the timeout of a copy to change, got %s and %s", client.httpClient.Timeout, injected.Timeout)
+	}
+
+	client = NewSearchClient("http://search.test/users", "", WithHTTPClient(nil))
+	if client.httpClient.Timeout != time.Second {
+		t.Errorf("Expected the default client for nil, got timeout %s", client.httpClient.Timeout)
+	}
+
+	client = NewSearchClient("http://bad host", "")
+	result, err = client.FindUsers(SearchRequest{})
+	if result != nil || err == nil || !strings.HasPrefix(err.Error(), "bad request") {
+		t.Errorf("Expected \"bad request\" error, got err: %#v result: %#v", err, result)
+	}
+}
+
+func TestOrderAndFilterParams(t *testing.T) {
+	var last *http.Request
+	client := NewSearchClient("http://search.test/users", "token", WithTransport(fakeTransport(`[]`, &last)))
+	cases := map[string]SearchRequest{
+		"limit=1&offset=0&order=-Age%2CName&order_by=0&order_field=&query=": {
+			Order: []SortKey{{"Age", true}, {"Name", false}},
+		},
+		"about=x+y&age_max=30&age_min=20&gender=female&limit=1&offset=0&order_by=0&order_field=&query=": {
+			Filter: Filter{AgeMin: 20, AgeMax: 30, Gender: "female", About: "x y"},
+		},
+		// the cursor parameter is sent even if empty, the limit is as is
+		"cursor=&limit=5&offset=0&order_by=0&order_field=&query=": {
+			Limit: 5, CursorPaging: true,
+		},
+		"cursor=abc&limit=5&offset=0&order_by=0&order_field=&query=": {
+			Limit: 5, Cursor: "abc",
+		},
+		// only the set filters are sent
+		"age_min=20&limit=1&offset=0&order_by=1&order_field=Id&query=": {
+			OrderField: "Id", OrderBy: OrderByDesc, Filter: Filter{AgeMin: 20},
+		},
+	}
+	for expected, req := range cases {
+		if _, err := client.FindUsers(req); err != nil {
+			t.Fatal(err)
+		}
+		if last.URL.RawQuery != expected {
+			t.Errorf("Expected %s, got %s", expected, last.URL.RawQuery)
+		}
+	}
+}
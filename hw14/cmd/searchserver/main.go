@@ -0,0 +1,162 @@
+// Command searchserver serves the search over dataset.xml:
+//
+//	go run ./cmd/searchserver -tokens secret -data dataset.xml
+//	curl -H 'AccessToken: secret' 'localhost:8080/?query=Lorem&order_field=Age&order_by=1&limit=5&offset=0'
+//
+// The users of -data are indexed for order_field=relevance. -data may also
+// be a file of json lines, and -dsn serves a table of a SQLite database
+// instead, with no relevance (see server.SQL). The SQLite driver needs cgo
+// and is only built in with the sqlite tag:
+//
+//	go run -tags sqlite ./cmd/searchserver -tokens secret -dsn users.db -table users
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"hw4/server"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run serves until ctx is done, the address is logged once it listens
+func run(ctx context.Context, args []string, logOut io.Writer) error {
+	flags := flag.NewFlagSet("searchserver", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	dataPath := flags.String("data", "dataset.xml", "users file, xml for the .xml extension and json lines otherwise")
+	driver := flags.String("driver", "sqlite3", "database/sql driver for -dsn")
+	dsn := flags.String("dsn", "", "database to serve instead of -data")
+	table := flags.String("table", "users", "table of users in the -dsn database")
+	tokens := flags.String("tokens", "", "comma separated access tokens")
+	tokensPath := flags.String("tokens-file", "", "file with an access token per line")
+	maxLimit := flags.Int("max-limit", server.DefaultMaxLimit, "the greatest limit of a request")
+	cursorKey := flags.String("cursor-key", "", "key to sign cursors with, for cursors that outlive the process; random if empty")
+	flags.SetOutput(logOut)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	allowed := []string{}
+	for _, token := range strings.Split(*tokens, ",") {
+		allowed = append(allowed, strings.TrimSpace(token))
+	}
+	if *tokensPath != "" {
+		fromFile, err := readTokens(*tokensPath)
+		if err != nil {
+			return err
+		}
+		allowed = append(allowed, fromFile...)
+	}
+	if !hasToken(allowed) {
+		return fmt.Errorf("no access tokens, use -tokens or -tokens-file")
+	}
+	var repo server.Repository
+	source := ""
+	if *dsn != "" {
+		db, err := sql.Open(*driver, *dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("database %s: %w", *dsn, err)
+		}
+		sqlRepo, err := server.NewSQL(db, *table)
+		if err != nil {
+			return err
+		}
+		// fail now and not on every request if there is no table
+		if _, err := sqlRepo.Search(ctx, server.Query{}); err != nil {
+			return fmt.Errorf("table %s: %w", *table, err)
+		}
+		repo = sqlRepo
+		source = fmt.Sprintf("table %s of %s", *table, *dsn)
+	} else {
+		users, err := server.LoadFile(*dataPath)
+		if err != nil {
+			return fmt.Errorf("data %s: %w", *dataPath, err)
+		}
+		repo = server.NewIndex(users)
+		source = fmt.Sprintf("%d users", len(users))
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	logger := log.New(logOut, "", log.LstdFlags)
+	handler := server.New(repo, allowed)
+	handler.ErrorLog = logger
+	handler.MaxLimit = *maxLimit
+	if *cursorKey != "" {
+		handler.CursorKey = []byte(*cursorKey)
+	}
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          logger,
+	}
+	logger.Printf("serving %s on %s", source, ln.Addr())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- httpServer.Serve(ln)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func readTokens(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	tokens := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens = append(tokens, strings.TrimSpace(scanner.Text()))
+	}
+	return tokens, scanner.Err()
+}
+
+func hasToken(tokens []string) bool {
+	for _, token := range tokens {
+		if token != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hw4/server"
+)
+
+const datasetPath = "../../dataset.xml"
+
+// logBuffer is written by the server goroutine and read by the test
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+var servingRe = regexp.MustCompile(`serving (.+) on (\S+)`)
+
+// startRun runs the server with args until stop is called, stop returns
+// the error of run
+func startRun(t *testing.T, args []string) (source, addr string, stop func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	logOut := &logBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, append([]string{"-addr", "127.0.0.1:0"}, args...), logOut)
+	}()
+	stop = func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Server did not stop")
+			return nil
+		}
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if m := servingRe.FindStringSubmatch(logOut.String()); m != nil {
+			return m[1], m[2], stop
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	t.Fatalf("Server did not start: %s", logOut)
+	return "", "", nil
+}
+
+// status returns the status of a search for a user with the token
+func status(t *testing.T, addr, token string) int {
+	return statusOf(t, addr, token, "limit=1&offset=0")
+}
+
+func statusOf(t *testing.T, addr, token, query string) int {
+	// without keep-alives no connection is left for Shutdown to wait for
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	req, _ := http.NewRequest("GET", "http://"+addr+"/?"+query, nil)
+	req.Header.Set("AccessToken", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRun(t *testing.T) {
+	tokensPath := filepath.Join(t.TempDir(), "tokens")
+	if err := ioutil.WriteFile(tokensPath, []byte("from-file\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source, addr, stop := startRun(t, []string{"-data", datasetPath, "-tokens", "a, b", "-tokens-file", tokensPath, "-cursor-key", "secret", "-max-limit", "10"})
+	if source != "35 users" {
+		t.Errorf("Unexpected source %q", source)
+	}
+	for token, expected := range map[string]int{"b": http.StatusOK, "from-file": http.StatusOK, "c": http.StatusUnauthorized} {
+		if got := status(t, addr, token); got != expected {
+			t.Errorf("%s: expected %d, got %d", token, expected, got)
+		}
+	}
+	if got := statusOf(t, addr, "a", "query=boyd&order_field=relevance&limit=1&offset=0"); got != http.StatusOK {
+		t.Errorf("Expected 200 by relevance, got %d", got)
+	}
+	if got := statusOf(t, addr, "a", "limit=11&offset=0"); got != http.StatusBadRequest {
+		t.Errorf("Expected 400 above -max-limit, got %d", got)
+	}
+
+	// cursors signed with the key elsewhere are taken
+	users, err := server.LoadDataset(datasetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := server.New(users, []string{"a"})
+	handler.CursorKey = []byte("secret")
+	signer := httptest.NewServer(handler)
+	defer signer.Close()
+	req, _ := http.NewRequest("GET", signer.URL+"/?limit=1&offset=0&cursor=", nil)
+	req.Header.Set("AccessToken", "a")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	cursor := resp.Header.Get("Next-Cursor")
+	if got := statusOf(t, addr, "a", "limit=1&offset=0&cursor="+cursor); cursor == "" || got != http.StatusOK {
+		t.Errorf("Expected 200 for cursor %q, got %d", cursor, got)
+	}
+
+	if err := stop(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestRunSQL(t *testing.T) {
+	skipWithoutSQLite(t)
+	dsn := filepath.Join(t.TempDir(), "users.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo, err := server.NewSQL(db, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := server.LoadDataset(datasetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := repo.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Insert(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+
+	source, addr, stop := startRun(t, []string{"-dsn", dsn, "-table", "people", "-tokens", "a"})
+	if source != "table people of "+dsn {
+		t.Errorf("Unexpected source %q", source)
+	}
+	if got := status(t, addr, "a"); got != http.StatusOK {
+		t.Errorf("Expected 200, got %d", got)
+	}
+	// a table has no index for relevance
+	if got := statusOf(t, addr, "a", "query=boyd&order_field=relevance&limit=1&offset=0"); got != http.StatusBadRequest {
+		t.Errorf("Expected 400 by relevance, got %d", got)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	for expected, args := range map[string][]string{
+		"table users: ":      {"-dsn", dsn},
+		"bad table name":     {"-dsn", dsn, "-table", "a b"},
+		"unknown driver":     {"-dsn", dsn, "-driver", "nosql"},
+		"database /no/such/": {"-dsn", "/no/such/dir/users.db"},
+	} {
+		err := run(ctx, append(args, "-tokens", "a"), ioutil.Discard)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%v: expected %q error, got %v", args, expected, err)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	cases := map[string][]string{
+		"no access tokens":          {"-data", datasetPath},
+		"no access tokens, use":     {"-data", datasetPath, "-tokens", " , "},
+		"data no_such.xml":          {"-data", "no_such.xml", "-tokens", "a"},
+		"no_such_tokens":            {"-data", datasetPath, "-tokens-file", "no_such_tokens"},
+		"flag provided but not def": {"-port", "80"},
+	}
+	for expected, args := range cases {
+		err := run(context.Background(), args, ioutil.Discard)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%v: expected %q error, got %v", args, expected, err)
+		}
+	}
+	if _, err := os.Stat(datasetPath); err != nil {
+		t.Fatal(err)
+	}
+	err := run(context.Background(), []string{"-addr", "bad address", "-data", datasetPath, "-tokens", "a"}, ioutil.Discard)
+	if err == nil {
+		t.Errorf("Expected a listen error")
+	}
+}
+
+// skipWithoutSQLite skips the test if the SQLite driver is not built in,
+// it needs cgo
+func skipWithoutSQLite(t *testing.T) {
+	for _, driver := range sql.Drivers() {
+		if driver == "sqlite3" {
+			return
+		}
+	}
+	t.Skip("no sqlite3 driver without cgo")
+}
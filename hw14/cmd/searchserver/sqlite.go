@@ -0,0 +1,6 @@
+//go:build sqlite && cgo
+// +build sqlite,cgo
+
+package main
+
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrUnauthorized is returned when the server does not accept the AccessToken
+	ErrUnauthorized = errors.New("Bad AccessToken")
+	// ErrTimeout matches every *TimeoutError
+	ErrTimeout = errors.New("timeout")
+)
+
+// TimeoutError is returned when the client timeout or the context deadline
+// passes before the response is read
+type TimeoutError struct {
+	// Params are the query parameters of the request
+	Params string
+	Err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return "timeout for " + e.Params
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// BadOrderFieldError is returned when the server can't order by Field
+type BadOrderFieldError struct {
+	Field string
+}
+
+func (e *BadOrderFieldError) Error() string {
+	// sic, callers may match the message
+	return fmt.Sprintf("OrderFeld %s invalid", e.Field)
+}
+
+// BadFilterError is returned when the server rejects a filter, Filter is
+// its query parameter, like age_min
+type BadFilterError struct {
+	Filter string
+	Reason string
+}
+
+func (e *BadFilterError) Error() string {
+	return fmt.Sprintf("filter %s invalid: %s", e.Filter, e.Reason)
+}
+
+// BadCursorError is returned when the server rejects a cursor, like one
+// of another query or of a server with another key
+type BadCursorError struct {
+	Reason string
+}
+
+func (e *BadCursorError) Error() string {
+	return "cursor invalid: " + e.Reason
+}
+
+// ServerError is returned for 5xx responses, after the retries if any
+type ServerError struct {
+	Status int
+	Body   []byte
+}
+
+func (e *ServerError) Error() string {
+	if e.Status == http.StatusInternalServerError {
+		return "SearchServer fatal error"
+	}
+	return fmt.Sprintf("SearchServer error: %d %s", e.Status, http.StatusText(e.Status))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTypedErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	_, err := NewSearchClient(ts.URL, "Bad Token").FindUsers(SearchRequest{})
+	if !errors.Is(err, ErrUnauthorized) || err.Error() != "Bad AccessToken" {
+		t.Errorf("Expected ErrUnauthorized, got %#v", err)
+	}
+
+	_, err = NewSearchClient(ts.URL, "").FindUsers(SearchRequest{OrderField: "About"})
+	orderErr := &BadOrderFieldError{}
+	if !errors.As(err, &orderErr) || orderErr.Field != "About" || err.Error() != "OrderFeld About invalid" {
+		t.Errorf("Expected *BadOrderFieldError, got %#v", err)
+	}
+
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		if r.FormValue("cursor") != "" {
+			w.Write([]byte(`{"Error":"ErrorBadCursor","Field":"cursor","Reason":"bad signature"}`))
+		} else if r.FormValue("order") != "" {
+			w.Write([]byte(`{"Error":"ErrorBadOrderField","Field":"Gender","Reason":"unknown field"}`))
+		} else {
+			w.Write([]byte(`{"Error":"ErrorBadFilter","Field":"age_max","Reason":"is less than age_min"}`))
+		}
+	}))
+	defer badRequest.Close()
+	// the field comes from the server with Order
+	_, err = NewSearchClient(badRequest.URL, "").FindUsers(SearchRequest{Order: []SortKey{{"Id", false}, {"Gender", true}}})
+	if !errors.As(err, &orderErr) || orderErr.Field != "Gender" {
+		t.Errorf("Expected *BadOrderFieldError, got %#v", err)
+	}
+	_, err = NewSearchClient(badRequest.URL, "").FindUsers(SearchRequest{Filter: Filter{AgeMin: 30, AgeMax: 20}})
+	filterErr := &BadFilterError{}
+	if !errors.As(err, &filterErr) || filterErr.Filter != "age_max" || filterErr.Reason != "is less than age_min" ||
+		err.Error() != "filter age_max invalid: is less than age_min" {
+		t.Errorf("Expected *BadFilterError, got %#v", err)
+	}
+
+	_, err = NewSearchClient(badRequest.URL, "").FindUsers(SearchRequest{Limit: 1, Cursor: "forged"})
+	cursorErr := &BadCursorError{}
+	if !errors.As(err, &cursorErr) || cursorErr.Reason != "bad signature" || err.Error() != "cursor invalid: bad signature" {
+		t.Errorf("Expected *BadCursorError, got %#v", err)
+	}
+
+	_, err = NewSearchClient(ts.URL, "Internal Error").FindUsers(SearchRequest{})
+	serverErr := &ServerError{}
+	if !errors.As(err, &serverErr) || serverErr.Status != http.StatusInternalServerError || err.Error() != "SearchServer fatal error" {
+		t.Errorf("Expected *ServerError, got %#v", err)
+	}
+
+	// other 5xx are server errors too, the body is kept
+	ts503 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("maintenance"))
+	}))
+	defer ts503.Close()
+	_, err = NewSearchClient(ts503.URL, "").FindUsers(SearchRequest{})
+	if !errors.As(err, &serverErr) || serverErr.Status != http.StatusServiceUnavailable || string(serverErr.Body) != "maintenance" ||
+		err.Error() != "SearchServer error: 503 Service Unavailable" {
+		t.Errorf("Expected *ServerError, got %#v", err)
+	}
+}
+
+func TestTimeoutErrors(t *testing.T) {
+	ts := blockingServer()
+	defer ts.Close()
+
+	_, err := NewSearchClient(ts.URL, "", WithTimeout(20*time.Millisecond)).FindUsers(SearchRequest{Limit: 3})
+	timeoutErr := &TimeoutError{}
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &timeoutErr) || timeoutErr.Params != "limit=4&offset=0&order_by=0&order_field=&query=" ||
+		err.Error() != "timeout for limit=4&offset=0&order_by=0&order_field=&query=" {
+		t.Errorf("Expected *TimeoutError, got %#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = NewSearchClient(ts.URL, "", WithTimeout(0)).FindUsersContext(ctx, SearchRequest{})
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected ErrTimeout wrapping context.DeadlineExceeded, got %#v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = NewSearchClient(ts.URL, "").FindUsersContext(ctx, SearchRequest{})
+	if errors.Is(err, ErrTimeout) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %#v", err)
+	}
+}
@@ -1,3 +1,5 @@
 module hw4
 
 go 1.16
+
+require github.com/mattn/go-sqlite3 v1.14.16
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+)
+
+// PageOptions configure a UserIterator
+type PageOptions struct {
+	// MaxResults stops the iteration after that many users, 0 for all
+	MaxResults int
+	// Prefetch requests the next page while the current one is iterated
+	Prefetch bool
+}
+
+type pageResult struct {
+	resp *SearchResponse
+	err  error
+}
+
+// UserIterator walks the users found for a request page by page:
+//
+//	it := client.Users(ctx, req, PageOptions{})
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+//
+// Pages have req.Limit users, MaxPageSize if it is 0, and start at req.Offset.
+// With req.CursorPaging the pages after the first one go by their cursors.
+type UserIterator struct {
+	srv    *SearchClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    SearchRequest // the next page to request
+	opts   PageOptions
+
+	page     []User
+	pos      int
+	user     User
+	fetched  int  // users requested so far
+	last     bool // no pages after page
+	prefetch chan pageResult
+	err      error
+}
+
+// Users returns an iterator over all the users found for req
+func (srv *SearchClient) Users(ctx context.Context, req SearchRequest, opts PageOptions) *UserIterator {
+	if req.Limit <= 0 || req.Limit > MaxPageSize {
+		req.Limit = MaxPageSize
+	}
+	req.CursorPaging = req.CursorPaging || req.Cursor != ""
+	ctx, cancel := context.WithCancel(ctx)
+	return &UserIterator{srv: srv, ctx: ctx, cancel: cancel, req: req, opts: opts}
+}
+
+// Next moves to the next user, false is returned after the last one
+// or on an error
+func (it *UserIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.pos >= len(it.page) {
+		if it.last {
+			return false
+		}
+		if err := it.nextPage(); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	it.user = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// User returns the current user
+func (it *UserIterator) User() User {
+	return it.user
+}
+
+// Err returns the error that stopped the iteration
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration early, a prefetch in progress is cancelled
+func (it *UserIterator) Close() {
+	it.cancel()
+	if it.prefetch != nil {
+		<-it.prefetch
+		it.prefetch = nil
+	}
+	it.last = true
+	it.page = nil
+}
+
+// pageRequest is the request of the next page, nil if there is none
+func (it *UserIterator) pageRequest() *SearchRequest {
+	req := it.req
+	if it.opts.MaxResults > 0 {
+		left := it.opts.MaxResults - it.fetched
+		if left <= 0 {
+			return nil
+		}
+		if left < req.Limit {
+			req.Limit = left
+		}
+	}
+	return &req
+}
+
+func (it *UserIterator) nextPage() error {
+	var res pageResult
+	if it.prefetch != nil {
+		res = <-it.prefetch
+		it.prefetch = nil
+	} else {
+		req := it.pageRequest()
+		if req == nil {
+			it.last = true
+			return nil
+		}
+		res.resp, res.err = it.srv.FindUsersContext(it.ctx, *req)
+	}
+	if res.err != nil {
+		return res.err
+	}
+
+	it.page, it.pos = res.resp.Users, 0
+	it.fetched += len(it.page)
+	if it.req.CursorPaging {
+		// the offset is counted from the cursor
+		it.req.Cursor, it.req.Offset = res.resp.NextCursor, 0
+	} else {
+		it.req.Offset += len(it.page)
+	}
+	// an empty page can't move the offset, there is nothing after it
+	it.last = !res.resp.NextPage || len(it.page) == 0
+	if it.last || !it.opts.Prefetch {
+		return nil
+	}
+	req := it.pageRequest()
+	if req == nil {
+		it.last = true
+		return nil
+	}
+	it.prefetch = make(chan pageResult, 1)
+	go func(req SearchRequest, out chan<- pageResult) {
+		resp, err := it.srv.FindUsersContext(it.ctx, req)
+		out <- pageResult{resp, err}
+	}(*req, it.prefetch)
+	return nil
+}
+
+// FindAllUsers returns the users found for req from all the pages,
+// at most maxResults of them if it is not 0
+func (srv *SearchClient) FindAllUsers(ctx context.Context, req SearchRequest, maxResults int) ([]User, error) {
+	it := srv.Users(ctx, req, PageOptions{MaxResults: maxResults})
+	defer it.Close()
+	users := []User{}
+	for it.Next() {
+		users = append(users, it.User())
+	}
+	return users, it.Err()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pagedServer is SearchServer that records the queries, fail answers some of
+// them instead. If release is not nil every answer takes a value from it.
+type pagedServer struct {
+	mu      sync.Mutex
+	queries []string
+	fail    func(n int) int
+	release chan struct{}
+}
+
+func (s *pagedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.queries = append(s.queries, "limit="+r.FormValue("limit")+"&offset="+r.FormValue("offset"))
+	n := len(s.queries)
+	s.mu.Unlock()
+
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if s.fail != nil {
+		if status := s.fail(n); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	SearchServer(w, r)
+}
+
+func (s *pagedServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.queries...)
+}
+
+func newPagedClient(t *testing.T, s *pagedServer) *SearchClient {
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return NewSearchClient(ts.URL, "")
+}
+
+func userIds(users []User) string {
+	ids := []string{}
+	for _, u := range users {
+		ids = append(ids, strconv.Itoa(u.Id))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestFindAllUsers(t *testing.T) {
+	s := &pagedServer{}
+	client := newPagedClient(t, s)
+
+	users, err := client.FindAllUsers(context.Background(), SearchRequest{Limit: 10}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 35 || users[0].Id != 0 || users[34].Id != 34 {
+		t.Errorf("Expected users 0 to 34, got %s", userIds(users))
+	}
+	expected := "limit=11&offset=0 limit=11&offset=10 limit=11&offset=20 limit=11&offset=30"
+	if got := strings.Join(s.requests(), " "); got != expected {
+		t.Errorf("Expected requests %s, got %s", expected, got)
+	}
+
+	// the last page is cut to the cap
+	s.queries = nil
+	users, err = client.FindAllUsers(context.Background(), SearchRequest{Limit: 5, Offset: 3}, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userIds(users) != "3,4,5,6,7,8,9,10,11,12,13,14" {
+		t.Errorf("Unexpected users %s", userIds(users))
+	}
+	expected = "limit=6&offset=3 limit=6&offset=8 limit=3&offset=13"
+	if got := strings.Join(s.requests(), " "); got != expected {
+		t.Errorf("Expected requests %s, got %s", expected, got)
+	}
+
+	// the whole dataset fits a page of the default size
+	users, err = client.FindAllUsers(context.Background(), SearchRequest{Query: "quis"}, 0)
+	if err != nil || len(users) == 0 || len(users) >= MaxPageSize {
+		t.Errorf("Unexpected result %d users, err: %#v", len(users), err)
+	}
+}
+
+func TestUserIteratorError(t *testing.T) {
+	s := &pagedServer{fail: func(n int) int {
+		if n == 3 {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}}
+	client := newPagedClient(t, s)
+
+	it := client.Users(context.Background(), SearchRequest{Limit: 4}, PageOptions{})
+	defer it.Close()
+	users := []User{}
+	for it.Next() {
+		users = append(users, it.User())
+	}
+	if userIds(users) != "0,1,2,3,4,5,6,7" {
+		t.Errorf("Expected the users of the first pages, got %s", userIds(users))
+	}
+	if err := it.Err(); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("Expected \"SearchServer fatal error\" error, got %#v", err)
+	}
+	if it.Next() {
+		t.Errorf("Expected no users after an error")
+	}
+}
+
+// waitRequests waits until the server got n requests
+func waitRequests(t *testing.T, s *pagedServer, n int) {
+	for deadline := time.Now().Add(5 * time.Second); len(s.requests()) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d requests, got %v", n, s.requests())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUserIteratorPrefetch(t *testing.T) {
+	s := &pagedServer{release: make(chan struct{}, 3)}
+	client := newPagedClient(t, s)
+	it := client.Users(context.Background(), SearchRequest{Limit: 10}, PageOptions{Prefetch: true, MaxResults: 25})
+	defer it.Close()
+
+	s.release <- struct{}{}
+	if !it.Next() || it.User().Id != 0 {
+		t.Fatalf("Unexpected first user %#v, err: %#v", it.User(), it.Err())
+	}
+	// the second page is requested while the first one is iterated
+	waitRequests(t, s, 2)
+
+	s.release <- struct{}{}
+	s.release <- struct{}{}
+	users := []User{it.User()}
+	for it.Next() {
+		users = append(users, it.User())
+	}
+	if it.Err() != nil || len(users) != 25 || users[24].Id != 24 {
+		t.Errorf("Unexpected users %s, err: %#v", userIds(users), it.Err())
+	}
+	expected := "limit=11&offset=0 limit=11&offset=10 limit=6&offset=20"
+	if got := strings.Join(s.requests(), " "); got != expected {
+		t.Errorf("Expected requests %s, got %s", expected, got)
+	}
+}
+
+func TestUserIteratorEarlyStop(t *testing.T) {
+	for _, prefetch := range []bool{false, true} {
+		s := &pagedServer{release: make(chan struct{}, 3)}
+		client := newPagedClient(t, s)
+		it := client.Users(context.Background(), SearchRequest{Limit: 3}, PageOptions{Prefetch: prefetch})
+
+		s.release <- struct{}{}
+		for i := 0; i < 2; i++ {
+			if !it.Next() {
+				t.Fatalf("prefetch %v: unexpected end, err: %#v", prefetch, it.Err())
+			}
+		}
+		if prefetch {
+			waitRequests(t, s, 2)
+		}
+		// the prefetch waits for release and is cancelled
+		it.Close()
+		if it.Next() || it.Err() != nil {
+			t.Errorf("prefetch %v: expected the end after Close, err: %#v", prefetch, it.Err())
+		}
+		if n := len(s.requests()); prefetch && n != 2 || !prefetch && n != 1 {
+			t.Errorf("prefetch %v: unexpected requests %v", prefetch, s.requests())
+		}
+	}
+
+	// the context stops the iteration too
+	s := &pagedServer{}
+	client := newPagedClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := client.Users(ctx, SearchRequest{Limit: 3}, PageOptions{})
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		if n++; n == 3 {
+			cancel()
+		}
+	}
+	if n != 3 || it.Err() == nil || !strings.HasPrefix(it.Err().Error(), "unknown error") {
+		t.Errorf("Expected an error after 3 users, got %d users and %#v", n, it.Err())
+	}
+}
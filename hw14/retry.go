@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy says how FindUsers repeats failed requests. Only timeouts,
+// connection resets and 500, 502, 503 and 504 are retried, the requests are
+// GETs so repeating them is safe.
+type RetryPolicy struct {
+	// attempts in total, 0 and 1 for no retries
+	MaxAttempts int
+	// the delay before the second attempt, it doubles with every next one
+	// up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// part of the delay that is random, 0.5 gives delays of 50-100%.
+	// Clients failed together don't come back together then.
+	Jitter float64
+	// OnAttempt is called after every attempt, it may be nil
+	OnAttempt func(a Attempt)
+}
+
+// DefaultRetryPolicy tries 3 times in about a third of a second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	Jitter:      0.5,
+}
+
+// Attempt is a finished request for RetryPolicy.OnAttempt
+type Attempt struct {
+	Number int // from 1
+	Status int // 0 if there is no response
+	Err    error
+	// Retry is true if another attempt follows after Delay
+	Retry bool
+	Delay time.Duration
+}
+
+// WithRetry makes the client repeat failed requests according to p
+func WithRetry(p RetryPolicy) Option {
+	return func(o *clientOptions) {
+		o.retry = p
+	}
+}
+
+// jitterRand is seeded, the global source is not with go 1.16 in go.mod
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// delay returns the delay after the attempt with the number n
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		jitterMu.Lock()
+		r := jitterRand.Float64()
+		jitterMu.Unlock()
+		d -= time.Duration(float64(d) * p.Jitter * r)
+	}
+	return d
+}
+
+// retryable reports if a failed attempt may succeed when repeated
+func retryable(status int, err error) bool {
+	if err != nil {
+		return isTimeout(err) || errors.Is(err, syscall.ECONNRESET)
+	}
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// send makes a request and reads the response
+func (srv *SearchClient) send(req *http.Request) (status int, header http.Header, body []byte, err error) {
+	resp, err := srv.do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, body, err
+}
+
+// sendRetrying sends req until it succeeds, fails for good, the attempts
+// run out or ctx is done
+func (srv *SearchClient) sendRetrying(ctx context.Context, req *http.Request) (status int, header http.Header, body []byte, err error) {
+	p := srv.retry
+	for n := 1; ; n++ {
+		status, header, body, err = srv.send(req)
+		a := Attempt{Number: n, Status: status, Err: err}
+		a.Retry = n < p.MaxAttempts && retryable(status, err) && ctx.Err() == nil
+		if a.Retry {
+			a.Delay = p.delay(n)
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(a)
+		}
+		if !a.Retry {
+			return status, header, body, err
+		}
+
+		timer := time.NewTimer(a.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	failReset = -1 // close the connection with RST
+	failBlock = -2 // answer after the client gives up
+)
+
+// flakyServer fails the first requests as failures say, with a status or
+// failReset or failBlock, and then works like SearchServer
+func flakyServer(failures ...int) (*httptest.Server, *int32) {
+	requests := new(int32)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(requests, 1))
+		if n > len(failures) {
+			SearchServer(w, r)
+			return
+		}
+		switch failure := failures[n-1]; failure {
+		case failReset:
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		case failBlock:
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(failure)
+		}
+	}))
+	return ts, requests
+}
+
+// noDelays retries right away, attempts are recorded
+func noDelays(maxAttempts int, attempts *[]Attempt) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: maxAttempts,
+		OnAttempt: func(a Attempt) {
+			a.Err = nil
+			*attempts = append(*attempts, a)
+		},
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ts, requests := flakyServer(http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusBadGateway, failReset, failBlock, http.StatusGatewayTimeout)
+	defer ts.Close()
+	attempts := []Attempt{}
+	// the transport itself repeats requests reset on a reused connection
+	transport := &http.Transport{DisableKeepAlives: true}
+	client := NewSearchClient(ts.URL, "", WithTimeout(100*time.Millisecond), WithTransport(transport), WithRetry(noDelays(10, &attempts)))
+
+	result, err := client.FindUsers(SearchRequest{Limit: 1, OrderField: "Id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatalf("Unexpected error %#v", err)
+	}
+	if len(result.Users) != 1 || result.Users[0].Id != 34 {
+		t.Errorf("Unexpected result %#v", result)
+	}
+	expected := []Attempt{
+		{Number: 1, Status: 503, Retry: true},
+		{Number: 2, Status: 500, Retry: true},
+		{Number: 3, Status: 502, Retry: true},
+		{Number: 4, Retry: true},
+		{Number: 5, Retry: true},
+		{Number: 6, Status: 504, Retry: true},
+		{Number: 7, Status: 200},
+	}
+	if !reflect.DeepEqual(attempts, expected) {
+		t.Errorf("Unexpected attempts\n%v\nexpected\n%v", attempts, expected)
+	}
+	if atomic.LoadInt32(requests) != 7 {
+		t.Errorf("Expected 7 requests, got %d", atomic.LoadInt32(requests))
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	ts, requests := flakyServer(http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusInternalServerError)
+	defer ts.Close()
+	attempts := []Attempt{}
+	client := NewSearchClient(ts.URL, "", WithRetry(noDelays(2, &attempts)))
+
+	result, err := client.FindUsers(SearchRequest{})
+	if result != nil || err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("Expected \"SearchServer fatal error\" error, got err: %#v result: %#v", err, result)
+	}
+	if len(attempts) != 2 || attempts[1].Retry || atomic.LoadInt32(requests) != 2 {
+		t.Errorf("Expected 2 attempts, got %v", attempts)
+	}
+
+	// the last failure is reported
+	ts, _ = flakyServer(failReset, failReset)
+	defer ts.Close()
+	client = NewSearchClient(ts.URL, "", WithTransport(&http.Transport{DisableKeepAlives: true}), WithRetry(noDelays(2, &attempts)))
+	result, err = client.FindUsers(SearchRequest{})
+	if result != nil || err == nil || !strings.HasPrefix(err.Error(), "unknown error") || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Expected \"unknown error\" error, got err: %#v result: %#v", err, result)
+	}
+}
+
+func TestRetryOnlyTransient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	for _, token := range []string{"Bad Token", "Bad Request Unknown Error", "Good Request Bad JSON"} {
+		attempts := []Attempt{}
+		client := NewSearchClient(ts.URL, token, WithRetry(noDelays(3, &attempts)))
+		if _, err := client.FindUsers(SearchRequest{}); err == nil {
+			t.Errorf("%s: expected error", token)
+		}
+		if len(attempts) != 1 || attempts[0].Retry {
+			t.Errorf("%s: expected no retries, got %v", token, attempts)
+		}
+	}
+
+	// a cancelled request is not repeated
+	ts, requests := flakyServer(failBlock)
+	defer ts.Close()
+	client := NewSearchClient(ts.URL, "", WithTimeout(0), WithRetry(noDelays(3, new([]Attempt))))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := client.FindUsersContext(ctx, SearchRequest{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %#v", err)
+	}
+	if atomic.LoadInt32(requests) != 1 {
+		t.Errorf("Expected 1 request, got %d", atomic.LoadInt32(requests))
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	ts, _ := flakyServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer ts.Close()
+	delays := []time.Duration{}
+	p := RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    25 * time.Millisecond,
+		OnAttempt: func(a Attempt) {
+			delays = append(delays, a.Delay)
+		},
+	}
+	start := time.Now()
+	if _, err := NewSearchClient(ts.URL, "", WithRetry(p)).FindUsers(SearchRequest{}); err != nil {
+		t.Fatalf("Unexpected error %#v", err)
+	}
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond, 0}
+	if !reflect.DeepEqual(delays, expected) {
+		t.Errorf("Expected delays %v, got %v", expected, delays)
+	}
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Errorf("Expected to wait for the delays, took %s", elapsed)
+	}
+
+	p = DefaultRetryPolicy
+	for n := 1; n <= 10; n++ {
+		max := p.BaseDelay << uint(n-1)
+		if max > p.MaxDelay {
+			max = p.MaxDelay
+		}
+		for i := 0; i < 100; i++ {
+			if d := p.delay(n); d > max || d < max/2 {
+				t.Fatalf("Delay %s after attempt %d is out of [%s, %s]", d, n, max/2, max)
+			}
+		}
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	ts, _ := flakyServer(http.StatusServiceUnavailable)
+	defer ts.Close()
+	p := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := NewSearchClient(ts.URL, "", WithRetry(p)).FindUsersContext(ctx, SearchRequest{})
+	if result != nil || err == nil || !strings.HasPrefix(err.Error(), "timeout") {
+		t.Errorf("Expected \"timeout\" error, got err: %#v result: %#v", err, result)
+	}
+}
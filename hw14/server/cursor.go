@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A cursor is the position after the last user of a page for the next
+// page of the same query:
+//
+//	base64(json payload) "." base64(HMAC-SHA256 of the payload)
+//
+// The signature keeps clients from making up positions, and the query
+// hash from using the cursor with another query.
+type cursorPayload struct {
+	Query string `json:"q"`
+	Id    int    `json:"id"`
+	Age   int    `json:"age,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+var (
+	errCursorFormat    = errors.New("malformed")
+	errCursorSignature = errors.New("bad signature")
+	errCursorQuery     = errors.New("made for another query")
+)
+
+// queryHash identifies what a cursor may be used with, the paging
+// parameters are left out
+func queryHash(q Query) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q %v %+v", q.Query, q.Order, q.Filter)))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func (s *Server) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.CursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+// encodeCursor makes the cursor for the page of q after u
+func (s *Server) encodeCursor(q Query, u User) string {
+	p := cursorPayload{Query: queryHash(q), Id: u.Id}
+	for _, key := range q.Order {
+		switch key.Field {
+		case "Age":
+			p.Age = u.Age
+		case "Name":
+			p.Name = u.Name
+		}
+	}
+	payload, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// decodeCursor checks a cursor for q and returns the user it is after
+func (s *Server) decodeCursor(q Query, cursor string) (*User, error) {
+	dot := strings.IndexByte(cursor, '.')
+	if dot < 0 {
+		return nil, errCursorFormat
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(cursor[:dot])
+	if err != nil {
+		return nil, errCursorFormat
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(cursor[dot+1:])
+	if err != nil {
+		return nil, errCursorFormat
+	}
+	if !hmac.Equal(signature, s.sign(payload)) {
+		return nil, errCursorSignature
+	}
+	p := cursorPayload{}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, errCursorFormat
+	}
+	if p.Query != queryHash(q) {
+		return nil, errCursorQuery
+	}
+	return &User{Id: p.Id, Age: p.Age, Name: p.Name}, nil
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters and the weights of the ways a query word can match
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	nameBoost = 2 // a word in Name counts as much as two in About
+
+	prefixWeight = 0.8
+	fuzzyWeight  = 0.5
+	minPrefix    = 2 // letters a query word needs to match as a prefix
+)
+
+// Index is a Memory with a full-text index of Name and About for the
+// relevance order. The words of a query, lower cased runs of letters and
+// digits, must all match a word of the user, as is, as a prefix of it or
+// with a few typos: 1 edit for words of 4 letters and more, 2 from 8.
+// The users are scored with BM25 per field, weaker matches score less.
+// An Index is never changed, so it is safe for concurrent use.
+type Index struct {
+	users Memory
+	byID  map[int]int
+	// vocabulary is every word of the users, sorted for prefix searches
+	vocabulary []string
+	fields     [2]fieldIndex // Name, About
+}
+
+type fieldIndex struct {
+	boost     float64
+	postings  map[string][]posting
+	lengths   []int // words per user
+	avgLength float64
+}
+
+// posting is a user with a word and how many times it has it
+type posting struct {
+	user, freq int
+}
+
+// NewIndex indexes the users, they must not be changed after
+func NewIndex(users Memory) *Index {
+	ix := &Index{users: users, byID: make(map[int]int, len(users))}
+	words := map[string]bool{}
+	for f, boost := range []float64{nameBoost, 1} {
+		field := fieldIndex{boost: boost, postings: map[string][]posting{}, lengths: make([]int, len(users))}
+		total := 0
+		for i, u := range users {
+			text := u.Name
+			if f == 1 {
+				text = u.About
+			}
+			freqs := map[string]int{}
+			tokens := tokenize(text)
+			for _, token := range tokens {
+				freqs[token]++
+			}
+			for word, freq := range freqs {
+				field.postings[word] = append(field.postings[word], posting{i, freq})
+				words[word] = true
+			}
+			field.lengths[i] = len(tokens)
+			total += len(tokens)
+		}
+		if len(users) > 0 {
+			field.avgLength = float64(total) / float64(len(users))
+		}
+		ix.fields[f] = field
+	}
+	for i, u := range users {
+		ix.byID[u.Id] = i
+	}
+	for word := range words {
+		ix.vocabulary = append(ix.vocabulary, word)
+	}
+	sort.Strings(ix.vocabulary)
+	return ix
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxEdits is how many typos a query word may have
+func maxEdits(word []rune) int {
+	switch {
+	case len(word) >= 8:
+		return 2
+	case len(word) >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance is the Levenshtein distance of a and b, or limit+1 if it
+// is more than limit
+func editDistance(a, b []rune, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(b)] > limit {
+		return limit + 1
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// variants returns the words of the index a query word matches with
+// their weights
+func (ix *Index) variants(word string) map[string]float64 {
+	variants := map[string]float64{}
+	match := func(w string, weight float64) {
+		if weight > variants[w] {
+			variants[w] = weight
+		}
+	}
+
+	if len([]rune(word)) >= minPrefix {
+		for i := sort.SearchStrings(ix.vocabulary, word); i < len(ix.vocabulary) && strings.HasPrefix(ix.vocabulary[i], word); i++ {
+			match(ix.vocabulary[i], prefixWeight)
+		}
+	}
+	runes := []rune(word)
+	if limit := maxEdits(runes); limit > 0 {
+		for _, w := range ix.vocabulary {
+			if d := editDistance(runes, []rune(w), limit); d > 0 && d <= limit {
+				match(w, fuzzyWeight)
+			}
+		}
+	}
+	if ix.contains(word) {
+		match(word, 1)
+	}
+	return variants
+}
+
+func (ix *Index) contains(word string) bool {
+	i := sort.SearchStrings(ix.vocabulary, word)
+	return i < len(ix.vocabulary) && ix.vocabulary[i] == word
+}
+
+// idf is the inverse document frequency of the users with any of the
+// variants of a query word, all of them score as the word then and an
+// exact match beats a rare typo
+func (f *fieldIndex) idf(variants map[string]float64) float64 {
+	users := map[int]bool{}
+	for variant := range variants {
+		for _, p := range f.postings[variant] {
+			users[p.user] = true
+		}
+	}
+	n, df := float64(len(f.lengths)), float64(len(users))
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+// bm25 keeps the best score of every user with the variant in the field
+// in best
+func (f *fieldIndex) bm25(variant string, weight, idf float64, best map[int]float64) {
+	for _, p := range f.postings[variant] {
+		tf := float64(p.freq)
+		norm := 1 - bm25B + bm25B*float64(f.lengths[p.user])/f.avgLength
+		if score := weight * f.boost * idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm); score > best[p.user] {
+			best[p.user] = score
+		}
+	}
+}
+
+// scores returns the users matching every word of query with their
+// scores, nil for all users with no words
+func (ix *Index) scores(query string) map[int]float64 {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+	total := map[int]float64{}
+	for i, word := range words {
+		variants := ix.variants(word)
+		matched := map[int]float64{}
+		for f := range ix.fields {
+			field := &ix.fields[f]
+			idf := field.idf(variants)
+			// the best variant counts in a field, not all of them
+			best := map[int]float64{}
+			for variant, weight := range variants {
+				field.bm25(variant, weight, idf, best)
+			}
+			for user, score := range best {
+				matched[user] += score
+			}
+		}
+		for user := range total {
+			if _, ok := matched[user]; !ok {
+				delete(total, user)
+			}
+		}
+		for user, score := range matched {
+			if _, ok := total[user]; ok || i == 0 {
+				total[user] += score
+			}
+		}
+	}
+	return total
+}
+
+// CanRank is always true, an Index is a Ranker
+func (ix *Index) CanRank() bool {
+	return true
+}
+
+// Search orders by relevance if q.Order has it and searches like Memory
+// otherwise
+func (ix *Index) Search(ctx context.Context, q Query) ([]User, error) {
+	if !q.Ranked() {
+		return ix.users.Search(ctx, q)
+	}
+	if err := q.checkPage(); err != nil {
+		return nil, err
+	}
+
+	scores := ix.scores(q.Query)
+	after, afterScore := User{}, 0.0
+	if q.After != nil {
+		after = *q.After
+		// the score is not in the cursor, the index has the user
+		if i, ok := ix.byID[after.Id]; ok {
+			afterScore = scores[i]
+		}
+	}
+
+	found := []int{}
+	for i, u := range ix.users {
+		if _, ok := scores[i]; (ok || scores == nil) && q.Filter.Match(u) &&
+			(q.After == nil || lessScored(q.Order, after, u, afterScore, scores[i])) {
+			found = append(found, i)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		return lessScored(q.Order, ix.users[a], ix.users[b], scores[a], scores[b])
+	})
+
+	if q.Offset >= len(found) {
+		return []User{}, nil
+	}
+	found = found[q.Offset:]
+	if q.Limit < len(found) {
+		found = found[:q.Limit]
+	}
+	users := make([]User, len(found))
+	for i, user := range found {
+		users[i] = ix.users[user]
+	}
+	return users, nil
+}
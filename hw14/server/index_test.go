@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Boyd Wolf, ÉLAN-vital 42x  ")
+	expected := []string{"boyd", "wolf", "élan", "vital", "42x"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		limit    int
+		expected int
+	}{
+		{"lorem", "lorem", 1, 0},
+		{"lorem", "lorme", 2, 2},
+		{"lorem", "lorm", 1, 1},
+		{"lorem", "ipsum", 2, 3},
+		{"ab", "abcd", 1, 2},
+		{"ab", "ba", 1, 2},
+		{"kitten", "sitting", 3, 3},
+		{"", "abc", 3, 3},
+		{"élan", "elan", 1, 1},
+	} {
+		if got := editDistance([]rune(c.a), []rune(c.b), c.limit); got != c.expected {
+			t.Errorf("%s %s %d: expected %d, got %d", c.a, c.b, c.limit, c.expected, got)
+		}
+	}
+}
+
+// rank searches by relevance only and returns the ids
+func rank(t *testing.T, ix *Index, query string) string {
+	users, err := ix.Search(context.Background(), Query{Query: query, Order: []SortKey{{Field: OrderRelevance}}, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids(users)
+}
+
+func TestIndexRanking(t *testing.T) {
+	ix := NewIndex(Memory{
+		{Id: 1, Name: "Ann", About: "apple banana cherry"},
+		{Id: 2, Name: "Bob", About: "apple apple banana cherry"},
+		{Id: 3, Name: "Cid", About: "apple one two three four five six seven eight"},
+		{Id: 4, Name: "Apple Dee", About: "banana"},
+		{Id: 5, Name: "Eve", About: "apples banana cherry"},
+		{Id: 6, Name: "Fay", About: "pear"},
+		{Id: 7, Name: "Gus", About: "aple banana cherry"},
+		{Id: 8, Name: "Hal", About: "strawberry"},
+	})
+	for query, expected := range map[string]string{
+		// Name counts more, more words count more, longer About less,
+		// a prefix and a typo less than the word
+		"apple": "4,2,1,5,3,7",
+		// every word must match
+		"apple cherry":  "2,1,5,7",
+		"apple pear":    "",
+		"APPLE, Cherry": "2,1,5,7",
+		// prefixes from 2 letters
+		"pea": "6",
+		"pe":  "6",
+		"p":   "",
+		// a typo from 4 letters, 2 from 8
+		"bnana":    "4,1,5,7,2",
+		"bnna":     "",
+		"strwbery": "8",
+		"strwbry":  "",
+		// no words, all users by Id
+		"":    "1,2,3,4,5,6,7,8",
+		" ,.": "1,2,3,4,5,6,7,8",
+	} {
+		if got := rank(t, ix, query); got != expected {
+			t.Errorf("%q: expected %s, got %s", query, expected, got)
+		}
+	}
+}
+
+func TestIndexDataset(t *testing.T) {
+	ix := NewIndex(loadUsers(t))
+	// without relevance an Index is a Memory
+	testSearch(t, ix)
+
+	if got := rank(t, ix, "boyd"); got != "0" {
+		t.Errorf("Expected 0, got %s", got)
+	}
+	if got := rank(t, ix, "Boid Wolff"); got != "0" {
+		t.Errorf("Expected 0 with typos, got %s", got)
+	}
+	if got := rank(t, ix, "Wol"); !strings.HasPrefix(got, "0") {
+		t.Errorf("Expected 0 first by a prefix, got %s", got)
+	}
+
+	ctx := context.Background()
+	all, _ := ix.Search(ctx, Query{Query: "lorem", Order: []SortKey{{Field: OrderRelevance}}, Limit: 100})
+	if len(all) < 5 {
+		t.Fatalf("Expected more users for lorem, got %d", len(all))
+	}
+	reversed, _ := ix.Search(ctx, Query{Query: "lorem", Order: []SortKey{{OrderRelevance, true}}, Limit: 100})
+	if ids(all[:2]) != "34,8" || len(reversed) != len(all) || reversed[len(reversed)-1].Id != 34 {
+		t.Errorf("Expected -relevance to put the most relevant last, got %s and %s", ids(all), ids(reversed))
+	}
+
+	// filters and pages after a user
+	q := Query{Query: "lorem", Order: []SortKey{{Field: OrderRelevance}}, Filter: Filter{Gender: "male"}, Limit: 100}
+	males, _ := ix.Search(ctx, q)
+	for _, u := range males {
+		if u.Gender != "male" {
+			t.Errorf("Unexpected user %+v", u)
+		}
+	}
+	q.After, q.Limit = &males[1], 2
+	if page, _ := ix.Search(ctx, q); ids(page) != ids(males[2:4]) {
+		t.Errorf("Expected %s after the 2nd user, got %s", ids(males[2:4]), ids(page))
+	}
+	// a user of another index counts as not relevant
+	q.After, q.Offset = &User{Id: 100}, 0
+	if page, _ := ix.Search(ctx, q); len(page) != 0 {
+		t.Errorf("Expected no users after an unknown one, got %s", ids(page))
+	}
+	q.After, q.Offset = nil, 100
+	if page, _ := ix.Search(ctx, q); len(page) != 0 {
+		t.Errorf("Expected no users, got %s", ids(page))
+	}
+	q.Order = []SortKey{{"Age", false}, {Field: OrderRelevance}}
+	q.Offset = 0
+	byAge, _ := ix.Search(ctx, q)
+	if len(byAge) != 2 || byAge[0].Age > byAge[1].Age {
+		t.Errorf("Expected users by age, got %+v", byAge)
+	}
+
+	q.Limit = -1
+	if _, err := ix.Search(ctx, q); err == nil {
+		t.Errorf("Expected error for a negative limit")
+	}
+
+	if empty := NewIndex(nil); rank(t, empty, "x") != "" || rank(t, empty, "") != "" {
+		t.Errorf("Expected nothing from an empty index")
+	}
+}
+
+func TestIndexCursors(t *testing.T) {
+	s := New(NewIndex(loadUsers(t)), []string{"token"})
+	all := []User{}
+	json.Unmarshal(get(t, s, "token", "query=lorem&order_field=relevance&limit=100&offset=0").Body.Bytes(), &all)
+
+	// the scores are not in the cursors, the index gets them again
+	paged := []User{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		users, next := page(t, s, "query=lorem&order_field=relevance&limit=3&offset=0", cursor)
+		paged = append(paged, users...)
+		if next == "" {
+			if pages != 4 {
+				t.Errorf("Expected 4 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = next
+	}
+	if len(all) != 10 || ids(paged) != ids(all) {
+		t.Errorf("Expected %s, got %s", ids(all), ids(paged))
+	}
+}
+
+// countingRepository wraps a Repository, it ranks if the wrapped one does
+type countingRepository struct {
+	Repository
+	searches int
+}
+
+func (r *countingRepository) Search(ctx context.Context, q Query) ([]User, error) {
+	r.searches++
+	return r.Repository.Search(ctx, q)
+}
+
+func (r *countingRepository) CanRank() bool {
+	ranker, ok := r.Repository.(Ranker)
+	return ok && ranker.CanRank()
+}
+
+func TestRankerWrapped(t *testing.T) {
+	query := "query=boyd&order_field=relevance&limit=5&offset=0"
+	ranked := &countingRepository{Repository: NewIndex(loadUsers(t))}
+	w := get(t, New(ranked, []string{"token"}), "token", query)
+	users := []User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || w.Code != http.StatusOK || ids(users) != "0" || ranked.searches != 1 {
+		t.Errorf("Expected user 0 from the wrapped index, got %d %s", w.Code, w.Body)
+	}
+
+	unranked := &countingRepository{Repository: loadUsers(t)}
+	w = get(t, New(unranked, []string{"token"}), "token", query)
+	errResp := SearchErrorResponse{}
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if w.Code != http.StatusBadRequest || errResp.Field != OrderRelevance || unranked.searches != 0 {
+		t.Errorf("Expected 400 from the wrapped memory, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Repository stores the users a Server searches
+type Repository interface {
+	// Search returns the users for q, the query is valid. Users that are
+	// equal in the order are ordered by Id, and so are all of them with
+	// no order, so that a page can end at any user (see Query.After).
+	// A negative Limit or Offset is an error.
+	Search(ctx context.Context, q Query) ([]User, error)
+}
+
+// Ranker is a Repository that may order by OrderRelevance, the Server
+// rejects such queries for other ones. A wrapper of a Repository asks the
+// wrapped one in CanRank.
+type Ranker interface {
+	Repository
+	// CanRank reports if Search takes queries ordered by relevance
+	CanRank() bool
+}
+
+// Memory is a Repository of users in memory. It is safe for concurrent
+// use if it is not changed.
+type Memory []User
+
+func (m Memory) Search(ctx context.Context, q Query) ([]User, error) {
+	if err := q.checkPage(); err != nil {
+		return nil, err
+	}
+	found := make([]User, 0, len(m))
+	for _, u := range m {
+		if (q.Query == "" || strings.Contains(u.Name, q.Query) || strings.Contains(u.About, q.Query)) && q.Filter.Match(u) &&
+			(q.After == nil || less(q.Order, *q.After, u)) {
+			found = append(found, u)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return less(q.Order, found[i], found[j])
+	})
+
+	if q.Offset >= len(found) {
+		return []User{}, nil
+	}
+	found = found[q.Offset:]
+	if q.Limit < len(found) {
+		found = found[:q.Limit]
+	}
+	return found, nil
+}
+
+// checkPage returns an error for a negative Limit or Offset
+func (q Query) checkPage() error {
+	if q.Limit < 0 || q.Offset < 0 {
+		return fmt.Errorf("bad page limit %d offset %d", q.Limit, q.Offset)
+	}
+	return nil
+}
+
+// less compares a and b by the keys in turn and then by Id, all users
+// are equally relevant
+func less(order []SortKey, a, b User) bool {
+	return lessScored(order, a, b, 0, 0)
+}
+
+// lessScored is less with the relevance scores of a and b
+func lessScored(order []SortKey, a, b User, scoreA, scoreB float64) bool {
+	for _, key := range order {
+		c := 0
+		switch {
+		case key.Field != OrderRelevance:
+			c = compare(key.Field, a, b)
+		case scoreA > scoreB:
+			c = -1
+		case scoreA < scoreB:
+			c = 1
+		}
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return a.Id < b.Id
+}
+
+// compare returns -1, 0 or 1 as the field of a is less, equal or
+// greater than the one of b
+func compare(field string, a, b User) int {
+	switch field {
+	case "Id":
+		return compareInts(a.Id, b.Id)
+	case "Age":
+		return compareInts(a.Age, b.Age)
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type xmlRow struct {
+	Id        int    `xml:"id"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Age       int    `xml:"age"`
+	About     string `xml:"about"`
+	Gender    string `xml:"gender"`
+}
+
+type xmlDataset struct {
+	Rows []xmlRow `xml:"row"`
+}
+
+// ReadDataset reads users from the xml of dataset.xml, Name is the first
+// name and the last name
+func ReadDataset(r io.Reader) (Memory, error) {
+	data := xmlDataset{}
+	if err := xml.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+	users := make(Memory, len(data.Rows))
+	for i, row := range data.Rows {
+		users[i] = User{
+			Id:     row.Id,
+			Name:   row.FirstName + " " + row.LastName,
+			Age:    row.Age,
+			About:  row.About,
+			Gender: row.Gender,
+		}
+	}
+	return users, nil
+}
+
+func LoadDataset(path string) (Memory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadDataset(file)
+}
+
+// ReadJSONLines reads a json User per line, as the server answers them.
+// Empty lines are skipped.
+func ReadJSONLines(r io.Reader) (Memory, error) {
+	users := Memory{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		u := User{}
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		users = append(users, u)
+	}
+	return users, scanner.Err()
+}
+
+func LoadJSONLines(path string) (Memory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadJSONLines(file)
+}
+
+// LoadFile loads an xml dataset for the .xml extension and json lines
+// for any other
+func LoadFile(path string) (Memory, error) {
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		return LoadDataset(path)
+	}
+	return LoadJSONLines(path)
+}
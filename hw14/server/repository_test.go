@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDataset(t *testing.T) {
+	users := loadUsers(t)
+	if len(users) != 35 {
+		t.Fatalf("Expected 35 users, got %d", len(users))
+	}
+	if u := users[0]; u.Id != 0 || u.Name != "Boyd Wolf" || u.Age != 22 || u.Gender != "male" || !strings.HasPrefix(u.About, "Nulla cillum") {
+		t.Errorf("Unexpected first user %#v", u)
+	}
+	if _, err := LoadDataset("no_such.xml"); err == nil {
+		t.Errorf("Expected error for a missing file")
+	}
+	if _, err := ReadDataset(strings.NewReader("<root><row>")); err == nil {
+		t.Errorf("Expected error for bad xml")
+	}
+}
+
+func TestLoadJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.jsonl")
+	data := `{"Id":7,"Name":"Ann Lee","Age":30,"About":"about","Gender":"female"}
+
+{"Id":3,"Name":"Bob","Age":20}
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadFile(path)
+	expected := Memory{{7, "Ann Lee", 30, "about", "female"}, {3, "Bob", 20, "", ""}}
+	if err != nil || !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected %v, got %v %v", expected, users, err)
+	}
+
+	if users, err := LoadFile(datasetPath); err != nil || len(users) != 35 {
+		t.Errorf("Expected the xml dataset, got %d users %v", len(users), err)
+	}
+	if _, err := LoadFile("no_such.jsonl"); err == nil {
+		t.Errorf("Expected error for a missing file")
+	}
+	_, err = ReadJSONLines(strings.NewReader("{\"Id\":1}\n{\"Id\":\"2\"}\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("Expected error on line 2, got %v", err)
+	}
+}
+
+// searchCases hold for every Repository with dataset.xml in it
+var searchCases = []struct {
+	q        Query
+	expected string
+}{
+	{Query{Order: []SortKey{{"Id", false}}, Limit: 5}, "0,1,2,3,4"},
+	{Query{Order: []SortKey{{"Id", true}}, Limit: 3, Offset: 1}, "33,32,31"},
+	{Query{Limit: 2, Offset: 33}, "33,34"},
+	{Query{Order: []SortKey{{"Id", false}}, Limit: 10, Offset: 35}, ""},
+	{Query{Order: []SortKey{{"Id", false}}, Limit: 0}, ""},
+	// ties are ordered by Id
+	{Query{Order: []SortKey{{"Age", false}}, Limit: 4}, "1,15,23,0"},
+	{Query{Order: []SortKey{{"Age", true}}, Limit: 3}, "13,32,6"},
+	{Query{Order: []SortKey{{"Name", false}}, Limit: 3}, "15,16,19"},
+	{Query{Order: []SortKey{{"Name", true}}, Limit: 2}, "13,33"},
+	// Name and About, case sensitive
+	{Query{Query: "Boyd", Limit: 10}, "0"},
+	{Query{Query: "boyd", Limit: 10}, ""},
+	{Query{Query: "Velit ullamco", Limit: 10}, "2"},
+	{Query{Query: "Lorem", Order: []SortKey{{"Age", false}}, Limit: 3, Offset: 2}, "20,8,5"},
+	// literally, not as patterns
+	{Query{Query: "%", Limit: 10}, ""},
+	{Query{Query: "B_yd", Limit: 10}, ""},
+	// several keys
+	{Query{Order: []SortKey{{"Age", true}, {"Name", false}}, Limit: 4}, "32,13,6,26"},
+	{Query{Order: []SortKey{{"Age", false}, {"Id", true}}, Limit: 4}, "23,15,1,0"},
+	{Query{Order: []SortKey{{"Name", false}, {"Age", false}}, Limit: 2, Offset: 33}, "33,13"},
+	// filters
+	{Query{Filter: Filter{AgeMin: 38}, Limit: 10}, "6,13,26,32"},
+	{Query{Filter: Filter{AgeMin: 38, AgeMax: 39}, Limit: 10}, "6,26"},
+	{Query{Filter: Filter{AgeMax: 21, Gender: "female"}, Order: []SortKey{{"Age", false}}, Limit: 10}, "1"},
+	{Query{Filter: Filter{Gender: "Female"}, Limit: 10}, ""},
+	{Query{Query: "Boyd", Filter: Filter{Gender: "female"}, Limit: 10}, ""},
+	{Query{Filter: Filter{About: "Nulla cillum"}, Limit: 10}, "0"},
+	{Query{Filter: Filter{About: "NULLA CILLUM"}, Limit: 10}, ""},
+	{Query{Filter: Filter{About: "%"}, Limit: 10}, ""},
+	{Query{Filter: Filter{About: "Boyd"}, Limit: 10}, ""},
+	// pages after a user
+	{Query{Order: []SortKey{{"Age", false}}, After: &User{Id: 15, Age: 21}, Limit: 3}, "23,0,14"},
+	{Query{Order: []SortKey{{"Age", true}, {"Name", false}}, After: &User{Id: 13, Age: 40, Name: "Whitley Davidson"}, Limit: 2}, "6,26"},
+	{Query{After: &User{Id: 30}, Limit: 10}, "31,32,33,34"},
+	{Query{Order: []SortKey{{"Id", true}}, After: &User{Id: 2}, Limit: 10}, "1,0"},
+	{Query{Query: "Lorem", Order: []SortKey{{"Age", false}}, After: &User{Id: 20, Age: 30}, Offset: 1, Limit: 2}, "7,34"},
+	// the user may be gone
+	{Query{Order: []SortKey{{"Age", false}}, After: &User{Id: 100, Age: 21}, Limit: 2}, "0,14"},
+}
+
+func testSearch(t *testing.T, repo Repository) {
+	for _, c := range searchCases {
+		got, err := repo.Search(context.Background(), c.q)
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", c.q, err)
+			continue
+		}
+		if ids(got) != c.expected {
+			t.Errorf("%+v: expected %s, got %s", c.q, c.expected, ids(got))
+		}
+	}
+	for _, q := range []Query{{Limit: -1}, {Limit: 1, Offset: -1}, {Order: []SortKey{{"Age", false}}, Limit: -1 << 62}} {
+		if _, err := repo.Search(context.Background(), q); err == nil {
+			t.Errorf("%+v: expected error", q)
+		}
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	testSearch(t, loadUsers(t))
+
+	// the order of the users in memory doesn't matter
+	unordered := Memory{{Id: 3, Age: 20}, {Id: 1, Age: 30}, {Id: 2, Age: 20}}
+	for _, c := range []struct {
+		q        Query
+		expected string
+	}{
+		{Query{Limit: 5}, "1,2,3"},
+		{Query{Order: []SortKey{{"Age", false}}, Limit: 5}, "2,3,1"},
+		{Query{Order: []SortKey{{"Age", true}}, After: &User{Id: 1, Age: 30}, Limit: 5}, "2,3"},
+	} {
+		if got, _ := unordered.Search(context.Background(), c.q); ids(got) != c.expected {
+			t.Errorf("%+v: expected %s, got %s", c.q, c.expected, ids(got))
+		}
+	}
+}
@@ -0,0 +1,376 @@
+// Package server serves the user search that SearchClient talks to.
+//
+// A request is a GET with the AccessToken header and the parameters
+//
+//	query        substring of Name or About, all users if empty
+//	order_field  Id, Age, Name or relevance, Name if empty
+//	order_by     1 ascending, -1 descending, 0 by Id, relevance ignores it
+//	order        comma separated fields to order by, each with - in
+//	             front for descending, it replaces order_field and order_by
+//	age_min      the least age, age_max the greatest
+//	gender       equal to Gender
+//	about        substring of About
+//	limit        the most users to return, up to Server.MaxLimit
+//	offset       users to skip after ordering
+//	cursor       the Next-Cursor of the previous page, empty for the first
+//
+// order_by goes like in the SearchServer the client is tested with, the
+// client names the values the other way round: OrderByAsc is -1.
+//
+// relevance puts the users most relevant to the query first, -relevance
+// last. The query is matched by words then, not as a substring, and only
+// a Repository that is a Ranker can do it, like Index.
+//
+// The answer is a json array of users, or 400 with {"Error": ...} for bad
+// parameters, 401 for a bad token and 500 if the Repository fails. Errors
+// for order fields, filters and cursors name them in Field and say why in
+// Reason.
+//
+// With the cursor parameter, even an empty one, an answer that is not the
+// last page has the Next-Cursor header. Cursors are opaque and signed,
+// unlike offsets they stay right when users are added or removed between
+// the pages. Users equal in the order are ordered by Id for that.
+//
+// Answers have an ETag of their content, a request with it in
+// If-None-Match gets 304 with no body if the answer is the same.
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+type SearchErrorResponse struct {
+	Error string
+	// Field is the bad order field or filter parameter
+	Field  string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
+// Errors of 400 answers
+const (
+	ErrorBadOrderField  = "ErrorBadOrderField"
+	ErrorBadOrderBy     = "ErrorBadOrderBy"
+	ErrorBadLimitField  = "ErrorBadLimitField"
+	ErrorBadOffsetField = "ErrorBadOffsetField"
+	ErrorBadFilter      = "ErrorBadFilter"
+	ErrorBadCursor      = "ErrorBadCursor"
+)
+
+// DefaultMaxLimit is the MaxLimit New sets
+const DefaultMaxLimit = 100
+
+// ErrorInternal is the error of 500 answers, the cause is only logged
+const ErrorInternal = "ErrorInternal"
+
+// Values of order_by
+const (
+	OrderAsc  = 1
+	OrderAsIs = 0
+	OrderDesc = -1
+)
+
+// Query is a parsed search request
+type Query struct {
+	Query string
+	// Order is empty for the order by Id, equal users are ordered by Id
+	Order  []SortKey
+	Filter Filter
+	// After is the last user of the previous page, only the users after
+	// it in the order are found. Only the Order fields and Id are used.
+	After  *User
+	Limit  int
+	Offset int
+}
+
+// SortKey is a field to order by, Id, Age, Name or OrderRelevance
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Filter narrows the users found, its zero value lets every user through
+type Filter struct {
+	AgeMin int
+	AgeMax int // 0 for no bound
+	Gender string
+	About  string
+}
+
+// Server answers search requests from a Repository. It is safe for
+// concurrent use if the repository is.
+type Server struct {
+	// ErrorLog gets the repository errors, the log package's standard
+	// logger if it is nil
+	ErrorLog *log.Logger
+	// CursorKey signs the cursors, New makes a random one. Servers with
+	// the same key take the cursors of each other, also after a restart.
+	CursorKey []byte
+	// MaxLimit is the greatest limit, a greater one is a bad request so
+	// that one request cannot make the repository load all its users.
+	// New sets DefaultMaxLimit.
+	MaxLimit int
+
+	repo   Repository
+	tokens [][]byte
+}
+
+// New makes a server accepting the tokens, with no tokens every
+// request is unauthorized. Empty tokens are ignored.
+func New(repo Repository, tokens []string) *Server {
+	s := &Server{repo: repo, CursorKey: make([]byte, 32), MaxLimit: DefaultMaxLimit}
+	if _, err := rand.Read(s.CursorKey); err != nil {
+		panic(err)
+	}
+	for _, token := range tokens {
+		if token != "" {
+			s.tokens = append(s.tokens, []byte(token))
+		}
+	}
+	return s
+}
+
+func (s *Server) authorized(token string) bool {
+	ok := false
+	for _, t := range s.tokens {
+		// every token is compared, so the time tells nothing
+		if subtle.ConstantTimeCompare(t, []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func badFilter(param, reason string) *SearchErrorResponse {
+	return &SearchErrorResponse{ErrorBadFilter, param, reason}
+}
+
+// OrderRelevance orders by the relevance to the query, the most relevant
+// users first, see Index
+const OrderRelevance = "relevance"
+
+var sortFields = map[string]bool{"Id": true, "Age": true, "Name": true, OrderRelevance: true}
+
+// ParseQuery reads the query parameters, a bad one gives the error
+// answer with one of the Error* constants
+func ParseQuery(r *http.Request) (Query, *SearchErrorResponse) {
+	q := Query{Query: r.FormValue("query")}
+	if order := r.FormValue("order"); order != "" {
+		if err := q.parseOrder(order); err != nil {
+			return q, err
+		}
+	} else if err := q.parseOrderBy(r.FormValue("order_field"), r.FormValue("order_by")); err != nil {
+		return q, err
+	}
+	if err := q.Filter.parse(r); err != nil {
+		return q, err
+	}
+
+	var err error
+	if q.Limit, err = strconv.Atoi(r.FormValue("limit")); err != nil || q.Limit < 0 {
+		return q, &SearchErrorResponse{Error: ErrorBadLimitField}
+	}
+	if q.Offset, err = strconv.Atoi(r.FormValue("offset")); err != nil || q.Offset < 0 {
+		return q, &SearchErrorResponse{Error: ErrorBadOffsetField}
+	}
+	return q, nil
+}
+
+func (q *Query) parseOrderBy(field, orderBy string) *SearchErrorResponse {
+	if field == "" {
+		field = "Name"
+	}
+	if !sortFields[field] {
+		return &SearchErrorResponse{ErrorBadOrderField, field, "unknown field"}
+	}
+	by := OrderAsIs
+	if orderBy != "" {
+		var err error
+		by, err = strconv.Atoi(orderBy)
+		if err != nil || by < OrderDesc || by > OrderAsc {
+			return &SearchErrorResponse{Error: ErrorBadOrderBy}
+		}
+	}
+	switch {
+	case field == OrderRelevance:
+		// most relevant first whatever order_by is, as is makes no sense
+		q.Order = []SortKey{{Field: OrderRelevance}}
+	case by != OrderAsIs:
+		q.Order = []SortKey{{field, by == OrderDesc}}
+	}
+	return nil
+}
+
+// Ranked reports if q is ordered by relevance
+func (q Query) Ranked() bool {
+	for _, key := range q.Order {
+		if key.Field == OrderRelevance {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Query) parseOrder(order string) *SearchErrorResponse {
+	seen := map[string]bool{}
+	for _, field := range strings.Split(order, ",") {
+		key := SortKey{Field: strings.TrimPrefix(field, "-")}
+		key.Desc = key.Field != field
+		switch {
+		case !sortFields[key.Field]:
+			return &SearchErrorResponse{ErrorBadOrderField, key.Field, "unknown field"}
+		case seen[key.Field]:
+			return &SearchErrorResponse{ErrorBadOrderField, key.Field, "repeated field"}
+		}
+		seen[key.Field] = true
+		q.Order = append(q.Order, key)
+	}
+	return nil
+}
+
+func (f *Filter) parse(r *http.Request) *SearchErrorResponse {
+	for _, bound := range []struct {
+		param string
+		value *int
+	}{{"age_min", &f.AgeMin}, {"age_max", &f.AgeMax}} {
+		v := r.FormValue(bound.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return badFilter(bound.param, "must be a non-negative integer")
+		}
+		*bound.value = n
+	}
+	if f.AgeMax > 0 && f.AgeMin > f.AgeMax {
+		return badFilter("age_max", "is less than age_min")
+	}
+	f.Gender = r.FormValue("gender")
+	f.About = r.FormValue("about")
+	return nil
+}
+
+// Match reports if u passes the filter
+func (f Filter) Match(u User) bool {
+	return u.Age >= f.AgeMin &&
+		(f.AgeMax == 0 || u.Age <= f.AgeMax) &&
+		(f.Gender == "" || u.Gender == f.Gender) &&
+		strings.Contains(u.About, f.About)
+}
+
+const maxInt = 1<<(strconv.IntSize-1) - 1
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r.Header.Get("AccessToken")) {
+		writeJSON(w, http.StatusUnauthorized, SearchErrorResponse{Error: "Bad AccessToken"})
+		return
+	}
+	q, badParam := ParseQuery(r)
+	if badParam != nil {
+		writeJSON(w, http.StatusBadRequest, badParam)
+		return
+	}
+	if q.Limit > s.MaxLimit {
+		writeJSON(w, http.StatusBadRequest, SearchErrorResponse{ErrorBadLimitField, "limit", "is greater than " + strconv.Itoa(s.MaxLimit)})
+		return
+	}
+	if ranker, ok := s.repo.(Ranker); q.Ranked() && !(ok && ranker.CanRank()) {
+		writeJSON(w, http.StatusBadRequest, SearchErrorResponse{ErrorBadOrderField, OrderRelevance, "not supported by the repository"})
+		return
+	}
+	cursors, withCursor := r.Form["cursor"]
+	if withCursor {
+		if q.Limit == 0 {
+			writeJSON(w, http.StatusBadRequest, SearchErrorResponse{ErrorBadLimitField, "limit", "must be positive with a cursor"})
+			return
+		}
+		if cursors[0] != "" {
+			after, err := s.decodeCursor(q, cursors[0])
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, SearchErrorResponse{ErrorBadCursor, "cursor", err.Error()})
+				return
+			}
+			q.After = after
+		}
+		// one more to see if there is a next page
+		if q.Limit == maxInt {
+			writeJSON(w, http.StatusBadRequest, SearchErrorResponse{ErrorBadLimitField, "limit", "too large with a cursor"})
+			return
+		}
+		q.Limit++
+	}
+
+	users, err := s.repo.Search(r.Context(), q)
+	if err != nil {
+		s.logf("search %+v: %v", q, err)
+		writeJSON(w, http.StatusInternalServerError, SearchErrorResponse{Error: ErrorInternal})
+		return
+	}
+	nextCursor := ""
+	if withCursor && len(users) == q.Limit {
+		users = users[:len(users)-1]
+		nextCursor = s.encodeCursor(q, users[len(users)-1])
+		w.Header().Set("Next-Cursor", nextCursor)
+	}
+
+	// users always marshal, the new line is as from writeJSON
+	body, _ := json.Marshal(users)
+	body = append(body, '\n')
+	sum := sha256.Sum256(append([]byte(nextCursor), body...))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "AccessToken")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// etagMatch reports if the If-None-Match header matches etag, weakly as
+// RFC 7232 says
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
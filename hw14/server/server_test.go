@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const datasetPath = "../dataset.xml"
+
+func loadUsers(t *testing.T) Memory {
+	users, err := LoadDataset(datasetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func ids(users []User) string {
+	list := []string{}
+	for _, u := range users {
+		list = append(list, strconv.Itoa(u.Id))
+	}
+	return strings.Join(list, ",")
+}
+
+func get(t *testing.T, h http.Handler, token, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/?"+query, nil)
+	if token != "" {
+		r.Header.Set("AccessToken", token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := New(loadUsers(t), []string{"", "first", "second"})
+
+	w := get(t, s, "second", "limit=2&offset=0&order_field=Id&order_by=-1&query=")
+	users := []User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Unexpected answer %d %s", w.Code, w.Body)
+	}
+	if ids(users) != "34,33" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected users %s", ids(users))
+	}
+
+	// order_field and order_by may be missing
+	w = get(t, s, "first", "limit=1&offset=0")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), `[{"Id":0,"Name":"Boyd Wolf"`) {
+		t.Errorf("Unexpected answer %d %s", w.Code, w.Body)
+	}
+
+	for _, token := range []string{"", "third", "firs", "first "} {
+		if w := get(t, s, token, "limit=1&offset=0"); w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %d", token, w.Code)
+		}
+	}
+	if w := get(t, New(loadUsers(t), nil), "", "limit=1&offset=0"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without tokens, got %d", w.Code)
+	}
+
+	for query, expected := range map[string]SearchErrorResponse{
+		"limit=1&offset=0&order_field=About":        {ErrorBadOrderField, "About", "unknown field"},
+		"limit=1&offset=0&order_by=2":               {Error: ErrorBadOrderBy},
+		"limit=1&offset=0&order_by=asc":             {Error: ErrorBadOrderBy},
+		"offset=0":                                  {Error: ErrorBadLimitField},
+		"limit=-1&offset=0":                         {Error: ErrorBadLimitField},
+		"limit=1":                                   {Error: ErrorBadOffsetField},
+		"limit=100&offset=0":                        {},
+		"limit=101&offset=0":                        {ErrorBadLimitField, "limit", "is greater than 100"},
+		"limit=1&offset=-5":                         {Error: ErrorBadOffsetField},
+		"limit=1&offset=0&order=Age,-About":         {ErrorBadOrderField, "About", "unknown field"},
+		"limit=1&offset=0&order=Age,":               {ErrorBadOrderField, "", "unknown field"},
+		"limit=1&offset=0&order=Age,-Age":           {ErrorBadOrderField, "Age", "repeated field"},
+		"limit=1&offset=0&order_field=relevance":    {ErrorBadOrderField, "relevance", "not supported by the repository"},
+		"limit=1&offset=0&order=Age,-relevance":     {ErrorBadOrderField, "relevance", "not supported by the repository"},
+		"limit=1&offset=0&age_min=x":                {ErrorBadFilter, "age_min", "must be a non-negative integer"},
+		"limit=1&offset=0&age_max=-1":               {ErrorBadFilter, "age_max", "must be a non-negative integer"},
+		"limit=1&offset=0&age_min=30&age_max=20":    {ErrorBadFilter, "age_max", "is less than age_min"},
+		"limit=x&offset=0&age_min=30&age_max=20":    {ErrorBadFilter, "age_max", "is less than age_min"},
+		"limit=1&offset=0&order=Id&order_field=Bad": {},
+	} {
+		w := get(t, s, "first", query)
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if expected.Error == "" {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: expected 200, got %d %s", query, w.Code, w.Body)
+			}
+			continue
+		}
+		if w.Code != http.StatusBadRequest || errResp != expected {
+			t.Errorf("%s: expected 400 %+v, got %d %s", query, expected, w.Code, w.Body)
+		}
+	}
+
+	// the other fields are left out if empty
+	if w := get(t, s, "first", "offset=0"); w.Body.String() != `{"Error":"ErrorBadLimitField"}`+"\n" {
+		t.Errorf("Unexpected answer %s", w.Body)
+	}
+
+	s.MaxLimit = 2
+	if w := get(t, s, "first", "limit=3&offset=0"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "is greater than 2") {
+		t.Errorf("Expected 400 above MaxLimit, got %d %s", w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("AccessToken", "first")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	for query, expected := range map[string]Query{
+		"query=a+b&order_field=Age&order_by=1&limit=7&offset=3": {Query: "a b", Order: []SortKey{{"Age", false}}, Limit: 7, Offset: 3},
+		"order_by=-1&limit=1&offset=0":                          {Order: []SortKey{{"Name", true}}, Limit: 1},
+		"order_field=Id&order_by=0&limit=1&offset=0":            {Limit: 1},
+		// order replaces order_field and order_by
+		"order=-Age,Name,-Id&order_field=Id&order_by=1&limit=1&offset=0": {Order: []SortKey{{"Age", true}, {"Name", false}, {"Id", true}}, Limit: 1},
+		"age_min=20&age_max=30&gender=male&about=x+y&limit=1&offset=0":   {Filter: Filter{20, 30, "male", "x y"}, Limit: 1},
+		"age_min=20&age_max=0&limit=1&offset=0":                          {Filter: Filter{AgeMin: 20}, Limit: 1},
+		// most relevant first whatever order_by is
+		"order_field=relevance&order_by=-1&limit=1&offset=0": {Order: []SortKey{{Field: OrderRelevance}}, Limit: 1},
+		"order_field=relevance&order_by=0&limit=1&offset=0":  {Order: []SortKey{{Field: OrderRelevance}}, Limit: 1},
+		"order=-relevance,Age&limit=1&offset=0":              {Order: []SortKey{{OrderRelevance, true}, {"Age", false}}, Limit: 1},
+	} {
+		q, errResp := ParseQuery(httptest.NewRequest("GET", "/?"+query, nil))
+		if errResp != nil || !reflect.DeepEqual(q, expected) {
+			t.Errorf("%s: expected %+v, got %+v %+v", query, expected, q, errResp)
+		}
+	}
+}
+
+type failingRepository struct{}
+
+func (failingRepository) Search(ctx context.Context, q Query) ([]User, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func TestServeHTTPRepositoryError(t *testing.T) {
+	logOut := &bytes.Buffer{}
+	s := New(failingRepository{}, []string{"token"})
+	s.ErrorLog = log.New(logOut, "", 0)
+	w := get(t, s, "token", "limit=1&offset=0")
+	errResp := SearchErrorResponse{}
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if w.Code != http.StatusInternalServerError || errResp.Error != ErrorInternal {
+		t.Errorf("Expected 500 %s, got %d %s", ErrorInternal, w.Code, w.Body)
+	}
+	if !strings.Contains(logOut.String(), "disk on fire") {
+		t.Errorf("Expected the error logged, got %q", logOut)
+	}
+
+	defer log.SetOutput(os.Stderr)
+	logOut.Reset()
+	log.SetOutput(logOut)
+	s.ErrorLog = nil
+	if w := get(t, s, "token", "limit=1&offset=0"); w.Code != http.StatusInternalServerError || !strings.Contains(logOut.String(), "disk on fire") {
+		t.Errorf("Expected 500 and the error in the standard log, got %d %q", w.Code, logOut)
+	}
+}
+
+func TestETag(t *testing.T) {
+	s := New(loadUsers(t), []string{"token"})
+	w := get(t, s, "token", "limit=2&offset=0")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || len(etag) != 34 || w.Header().Get("Vary") != "AccessToken" {
+		t.Fatalf("Unexpected answer %d %v", w.Code, w.Header())
+	}
+	if other := get(t, s, "token", "limit=3&offset=0").Header().Get("ETag"); other == etag {
+		t.Errorf("Expected another ETag for other users")
+	}
+
+	for ifNoneMatch, expected := range map[string]int{
+		etag:                    http.StatusNotModified,
+		"W/" + etag:             http.StatusNotModified,
+		`"other", ` + etag:      http.StatusNotModified,
+		"*":                     http.StatusNotModified,
+		`"other"`:               http.StatusOK,
+		strings.Trim(etag, `"`): http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", "/?limit=2&offset=0", nil)
+		r.Header.Set("AccessToken", "token")
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != expected || w.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected %d, got %d %v", ifNoneMatch, expected, w.Code, w.Header())
+		}
+		if expected == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: expected no body, got %s", ifNoneMatch, w.Body)
+		}
+	}
+}
+
+// page gets a page with the cursor and returns its users and Next-Cursor
+func page(t *testing.T, h http.Handler, query, cursor string) ([]User, string) {
+	w := get(t, h, "token", query+"&cursor="+url.QueryEscape(cursor))
+	users := []User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Unexpected answer %d %s", w.Code, w.Body)
+	}
+	return users, w.Header().Get("Next-Cursor")
+}
+
+func TestCursors(t *testing.T) {
+	s := New(loadUsers(t), []string{"token"})
+	query := "limit=10&offset=0&order=-Age,Name"
+	all, _ := loadUsers(t).Search(context.Background(), Query{Order: []SortKey{{"Age", true}, {"Name", false}}, Limit: 100})
+
+	paged := []User{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		users, next := page(t, s, query, cursor)
+		paged = append(paged, users...)
+		if next == "" {
+			if pages != 4 || len(users) != 5 {
+				t.Errorf("Expected the last of 4 pages with 5 users, got %d users on page %d", len(users), pages)
+			}
+			break
+		}
+		cursor = next
+	}
+	if ids(paged) != ids(all) {
+		t.Errorf("Expected %s, got %s", ids(all), ids(paged))
+	}
+
+	// a full last page has no cursor either
+	if _, next := page(t, s, "limit=35&offset=0", ""); next != "" {
+		t.Errorf("Unexpected cursor %s", next)
+	}
+	// no cursor without the parameter
+	if w := get(t, s, "token", "limit=1&offset=0"); w.Header().Get("Next-Cursor") != "" {
+		t.Errorf("Unexpected cursor %s", w.Header().Get("Next-Cursor"))
+	}
+
+	// servers with the same key take the cursors of each other
+	_, cursor = page(t, s, query, "")
+	other := New(loadUsers(t), []string{"token"})
+	if w := get(t, other, "token", query+"&cursor="+cursor); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for another key, got %d", w.Code)
+	}
+	other.CursorKey = s.CursorKey
+	if users, _ := page(t, other, query, cursor); ids(users) != ids(all[10:20]) {
+		t.Errorf("Expected %s, got %s", ids(all[10:20]), ids(users))
+	}
+}
+
+// mutableMemory is a Memory that the tests change between pages
+type mutableMemory struct {
+	users Memory
+}
+
+func (m *mutableMemory) Search(ctx context.Context, q Query) ([]User, error) {
+	return m.users.Search(ctx, q)
+}
+
+func TestCursorsChangedUsers(t *testing.T) {
+	users := loadUsers(t)
+	repo := &mutableMemory{users[:10]}
+	s := New(repo, []string{"token"})
+
+	first, cursor := page(t, s, "limit=4&offset=0&order_field=Id&order_by=1", "")
+	if ids(first) != "0,1,2,3" {
+		t.Fatalf("Unexpected first page %s", ids(first))
+	}
+	// the last user of the page goes and one comes before the page
+	repo.users = append(Memory{{Id: -1}}, append(users[:3:3], users[4:10]...)...)
+	second, _ := page(t, s, "limit=4&offset=0&order_field=Id&order_by=1", cursor)
+	if ids(second) != "4,5,6,7" {
+		t.Errorf("Expected 4,5,6,7, got %s", ids(second))
+	}
+}
+
+func TestBadCursors(t *testing.T) {
+	s := New(loadUsers(t), []string{"token"})
+	s.MaxLimit = maxInt
+	_, cursor := page(t, s, "limit=1&offset=0&order=Age", "")
+	dot := strings.IndexByte(cursor, '.')
+	signedBadJSON := base64.RawURLEncoding.EncodeToString([]byte("{")) + "." + base64.RawURLEncoding.EncodeToString(s.sign([]byte("{")))
+
+	for query, expected := range map[string]SearchErrorResponse{
+		"limit=0&offset=0&cursor=":                                     {ErrorBadLimitField, "limit", "must be positive with a cursor"},
+		"limit=9223372036854775807&offset=0&cursor=":                   {ErrorBadLimitField, "limit", "too large with a cursor"},
+		"limit=1&offset=0&cursor=abc":                                  {ErrorBadCursor, "cursor", "malformed"},
+		"limit=1&offset=0&cursor=a*c." + cursor[dot+1:]:                {ErrorBadCursor, "cursor", "malformed"},
+		"limit=1&offset=0&cursor=" + cursor[:dot] + ".a*c":             {ErrorBadCursor, "cursor", "malformed"},
+		"limit=1&offset=0&cursor=" + cursor[:dot] + "." + "AAAA":       {ErrorBadCursor, "cursor", "bad signature"},
+		"limit=1&offset=0&cursor=" + signedBadJSON:                     {ErrorBadCursor, "cursor", "malformed"},
+		"limit=1&offset=0&order=Name&cursor=" + cursor:                 {ErrorBadCursor, "cursor", "made for another query"},
+		"limit=1&offset=0&order=Age&age_min=30&cursor=" + cursor:       {ErrorBadCursor, "cursor", "made for another query"},
+		"limit=5&offset=10&order=Age&query=&cursor=" + cursor:          {},
+		"limit=1&offset=0&order_field=Age&order_by=1&cursor=" + cursor: {},
+	} {
+		w := get(t, s, "token", query)
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if expected.Error == "" {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: expected 200, got %d %s", query, w.Code, w.Body)
+			}
+			continue
+		}
+		if w.Code != http.StatusBadRequest || errResp != expected {
+			t.Errorf("%s: expected 400 %+v, got %d %s", query, expected, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SQL is a Repository over a database/sql table with the columns
+//
+//	id INTEGER, name TEXT, age INTEGER, about TEXT, gender TEXT
+//
+// The matching, ordering and paging run in the database. The queries are
+// for SQLite. The query is matched with instr, case sensitively as in
+// Memory, LIKE would ignore the case of ASCII letters.
+type SQL struct {
+	db    *sql.DB
+	table string
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewSQL makes a Repository over the table of db. The table name is put
+// into the queries as is, so it must be a plain identifier.
+func NewSQL(db *sql.DB, table string) (*SQL, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("bad table name %q", table)
+	}
+	return &SQL{db: db, table: table}, nil
+}
+
+// CreateTable creates the table and its indexes if they don't exist
+func (s *SQL) CreateTable(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			age INTEGER NOT NULL,
+			about TEXT NOT NULL,
+			gender TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.table + `_name ON ` + s.table + ` (name)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.table + `_age ON ` + s.table + ` (age)`,
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert adds the users in a transaction, all of them or none
+func (s *SQL) Insert(ctx context.Context, users []User) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO `+s.table+` (id, name, age, about, gender) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range users {
+		if _, err := stmt.ExecContext(ctx, u.Id, u.Name, u.Age, u.About, u.Gender); err != nil {
+			return fmt.Errorf("user %d: %w", u.Id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+var sqlColumns = map[string]string{"Id": "id", "Age": "age", "Name": "name"}
+
+// afterCondition matches the users after u in the order and then by id:
+// greater in the first key, or equal in it and greater in the second...
+func afterCondition(order []SortKey, u User) (string, []interface{}) {
+	keys := append(append([]SortKey{}, order...), SortKey{Field: "Id"})
+	values := map[string]interface{}{"Id": u.Id, "Age": u.Age, "Name": u.Name}
+	alternatives := []string{}
+	args := []interface{}{}
+	for i, key := range keys {
+		terms := []string{}
+		for _, equal := range keys[:i] {
+			terms = append(terms, sqlColumns[equal.Field]+` = ?`)
+			args = append(args, values[equal.Field])
+		}
+		if key.Desc {
+			terms = append(terms, sqlColumns[key.Field]+` < ?`)
+		} else {
+			terms = append(terms, sqlColumns[key.Field]+` > ?`)
+		}
+		args = append(args, values[key.Field])
+		alternatives = append(alternatives, `(`+strings.Join(terms, ` AND `)+`)`)
+	}
+	return `(` + strings.Join(alternatives, ` OR `) + `)`, args
+}
+
+func (s *SQL) Search(ctx context.Context, q Query) ([]User, error) {
+	// SQLite takes a negative LIMIT for no limit
+	if err := q.checkPage(); err != nil {
+		return nil, err
+	}
+	conditions := []string{}
+	args := []interface{}{}
+	if q.Query != "" {
+		conditions = append(conditions, `(instr(name, ?) > 0 OR instr(about, ?) > 0)`)
+		args = append(args, q.Query, q.Query)
+	}
+	f := q.Filter
+	if f.AgeMin > 0 {
+		conditions = append(conditions, `age >= ?`)
+		args = append(args, f.AgeMin)
+	}
+	if f.AgeMax > 0 {
+		conditions = append(conditions, `age <= ?`)
+		args = append(args, f.AgeMax)
+	}
+	if f.Gender != "" {
+		conditions = append(conditions, `gender = ?`)
+		args = append(args, f.Gender)
+	}
+	if f.About != "" {
+		conditions = append(conditions, `instr(about, ?) > 0`)
+		args = append(args, f.About)
+	}
+	if q.After != nil {
+		condition, afterArgs := afterCondition(q.Order, *q.After)
+		conditions = append(conditions, condition)
+		args = append(args, afterArgs...)
+	}
+
+	query := `SELECT id, name, age, about, gender FROM ` + s.table
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	query += ` ORDER BY `
+	for _, key := range q.Order {
+		query += sqlColumns[key.Field]
+		if key.Desc {
+			query += ` DESC, `
+		} else {
+			query += ` ASC, `
+		}
+	}
+	query += `id LIMIT ? OFFSET ?`
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		u := User{}
+		if err := rows.Scan(&u.Id, &u.Name, &u.Age, &u.About, &u.Gender); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
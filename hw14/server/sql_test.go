@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// skipWithoutSQLite skips the test if the SQLite driver is not built in,
+// it needs cgo
+func skipWithoutSQLite(t *testing.T) {
+	for _, driver := range sql.Drivers() {
+		if driver == "sqlite3" {
+			return
+		}
+	}
+	t.Skip("no sqlite3 driver without cgo")
+}
+
+// openSQLite opens a new database with the default options, as a user would
+func openSQLite(t *testing.T) *sql.DB {
+	skipWithoutSQLite(t)
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newSQLUsers(t *testing.T) *SQL {
+	repo, err := NewSQL(openSQLite(t), "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := repo.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// again to check IF NOT EXISTS
+	if err := repo.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Insert(ctx, loadUsers(t)); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestSQLSearch(t *testing.T) {
+	repo := newSQLUsers(t)
+	testSearch(t, repo)
+
+	users, err := repo.Search(context.Background(), Query{Query: "Boyd", Limit: 1})
+	if err != nil || len(users) != 1 || users[0] != loadUsers(t)[0] {
+		t.Errorf("Expected Boyd Wolf, got %v %v", users, err)
+	}
+}
+
+func TestSQLErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, table := range []string{"", "users; DROP TABLE users", "1users", "us-ers"} {
+		if _, err := NewSQL(nil, table); err == nil {
+			t.Errorf("%q: expected error", table)
+		}
+	}
+
+	repo := newSQLUsers(t)
+	// a duplicate id fails the whole insert
+	err := repo.Insert(ctx, []User{{Id: 100, Name: "Inserted"}, {Id: 0, Name: "Duplicate"}})
+	if err == nil || err.Error()[:7] != "user 0:" {
+		t.Errorf("Expected error for user 0, got %v", err)
+	}
+	if users, _ := repo.Search(ctx, Query{Query: "Inserted", Limit: 10}); len(users) != 0 {
+		t.Errorf("Expected no inserted users, got %v", users)
+	}
+
+	missing, err := NewSQL(repo.db, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := missing.Search(ctx, Query{Limit: 1}); err == nil {
+		t.Errorf("Expected error for a missing table")
+	}
+	if err := missing.Insert(ctx, []User{{Id: 1}}); err == nil {
+		t.Errorf("Expected error for a missing table")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := repo.Search(cancelled, Query{Limit: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := repo.Insert(cancelled, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := repo.CreateTable(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	repo.db.Close()
+	if _, err := repo.Search(ctx, Query{Limit: 1}); err == nil {
+		t.Errorf("Expected error for a closed db")
+	}
+}
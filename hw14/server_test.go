@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"hw4/server"
+)
+
+func newServerClient(t *testing.T, repo server.Repository, token string) (*SearchClient, func()) {
+	ts := httptest.NewServer(server.New(repo, []string{"token"}))
+	return NewSearchClient(ts.URL, token), ts.Close
+}
+
+// repositories returns the users of dataset.xml in every kind of
+// Repository, SQL only with the SQLite driver
+func repositories(t *testing.T) map[string]server.Repository {
+	users, err := server.LoadDataset(XMLDataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPath := filepath.Join(t.TempDir(), "users.jsonl")
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, u := range users {
+		enc.Encode(u)
+	}
+	if err := ioutil.WriteFile(jsonPath, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jsonUsers, err := server.LoadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := map[string]server.Repository{"xml": users, "json": jsonUsers, "index": server.NewIndex(users)}
+	if !hasSQLite() {
+		return repos
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlUsers, err := server.NewSQL(db, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlUsers.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlUsers.Insert(context.Background(), users); err != nil {
+		t.Fatal(err)
+	}
+
+	repos["sql"] = sqlUsers
+	return repos
+}
+
+// TestServerPackage checks that the server package answers as SearchServer
+// with every Repository
+func TestServerPackage(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			testServerPackage(t, repo)
+		})
+	}
+}
+
+func testServerPackage(t *testing.T, repo server.Repository) {
+	client, closeServer := newServerClient(t, repo, "token")
+	defer closeServer()
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	testClient := NewSearchClient(ts.URL, "token")
+
+	requests := []SearchRequest{
+		{Limit: 5, Offset: 0, OrderField: "Id", OrderBy: OrderByAsc},
+		{Limit: 3, Offset: 2, OrderField: "Age", OrderBy: OrderByDesc},
+		{Limit: 30, Offset: 0, Query: "Boyd", OrderBy: OrderByAsIs},
+		{Limit: 10, Offset: 30, OrderField: "Name", OrderBy: OrderByAsc},
+		{Limit: 25, Offset: 0, Query: "Lorem", OrderField: "Age", OrderBy: OrderByAsc},
+	}
+	for _, req := range requests {
+		expected, err := testClient.FindUsers(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := client.FindUsers(req)
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", req, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%+v: expected %+v, got %+v", req, expected, got)
+		}
+	}
+
+	// several sort keys and filters, which SearchServer doesn't know
+	for expected, req := range map[string]SearchRequest{
+		"32,13,6,26": {Limit: 4, Order: []SortKey{{"Age", true}, {"Name", false}}},
+		"23,15,1,0":  {Limit: 4, Order: []SortKey{{"Age", false}, {"Id", true}}},
+		"26,6":       {Limit: 5, Order: []SortKey{{"Id", true}}, Filter: Filter{AgeMin: 38, AgeMax: 39}},
+		"1":          {Limit: 5, Filter: Filter{AgeMax: 21, Gender: "female"}},
+		"0":          {Limit: 5, Query: "Boyd", Filter: Filter{About: "Nulla"}},
+	} {
+		got, err := client.FindUsers(req)
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", req, err)
+			continue
+		}
+		if ids := userIds(got.Users); ids != expected {
+			t.Errorf("%+v: expected %s, got %s", req, expected, ids)
+		}
+	}
+
+	// cursors give the same pages as offsets
+	for _, req := range []SearchRequest{
+		{Limit: 10, Order: []SortKey{{"Age", true}, {"Name", false}}},
+		{Limit: 4, Query: "Lorem", OrderField: "Name", OrderBy: OrderByDesc},
+		{Limit: 25, Filter: Filter{Gender: "female"}},
+		// the offset is for the first page only
+		{Limit: 7, Offset: 5, OrderField: "Age", OrderBy: OrderByAsc},
+	} {
+		byOffset, err := client.FindAllUsers(context.Background(), req, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.CursorPaging = true
+		byCursor, err := client.FindAllUsers(context.Background(), req, 0)
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", req, err)
+		}
+		if userIds(byCursor) != userIds(byOffset) {
+			t.Errorf("%+v: expected %s, got %s", req, userIds(byOffset), userIds(byCursor))
+		}
+	}
+	first, err := client.FindUsers(SearchRequest{Limit: 25, CursorPaging: true})
+	if err != nil || len(first.Users) != 25 || !first.NextPage || first.NextCursor == "" {
+		t.Fatalf("Unexpected first page %+v %v", first, err)
+	}
+	last, err := client.FindUsers(SearchRequest{Limit: 25, Cursor: first.NextCursor})
+	if err != nil || userIds(last.Users) != "25,26,27,28,29,30,31,32,33,34" || last.NextPage || last.NextCursor != "" {
+		t.Errorf("Unexpected last page %+v %v", last, err)
+	}
+	_, err = client.FindUsers(SearchRequest{Limit: 25, Query: "other", Cursor: first.NextCursor})
+	cursorErr := &BadCursorError{}
+	if !errors.As(err, &cursorErr) || cursorErr.Reason != "made for another query" {
+		t.Errorf("Expected BadCursorError, got %v", err)
+	}
+
+	_, err = client.FindUsers(SearchRequest{Limit: 1, OrderField: "About"})
+	orderErr := &BadOrderFieldError{}
+	if !errors.As(err, &orderErr) || orderErr.Field != "About" {
+		t.Errorf("Expected BadOrderFieldError, got %v", err)
+	}
+	_, err = client.FindUsers(SearchRequest{Limit: 1, Order: []SortKey{{"Age", false}, {"About", false}}})
+	if !errors.As(err, &orderErr) || orderErr.Field != "About" {
+		t.Errorf("Expected BadOrderFieldError, got %v", err)
+	}
+	_, err = client.FindUsers(SearchRequest{Limit: 1, Filter: Filter{AgeMin: 30, AgeMax: 20}})
+	filterErr := &BadFilterError{}
+	if !errors.As(err, &filterErr) || filterErr.Filter != "age_max" {
+		t.Errorf("Expected BadFilterError, got %v", err)
+	}
+
+	testRelevance(t, client, repo)
+}
+
+func testRelevance(t *testing.T, client *SearchClient, repo server.Repository) {
+	req := SearchRequest{Limit: 3, Query: "lorem", OrderField: OrderFieldRelevance}
+	res, err := client.FindUsers(req)
+	if _, ok := repo.(*server.Index); !ok {
+		orderErr := &BadOrderFieldError{}
+		if !errors.As(err, &orderErr) || orderErr.Field != OrderFieldRelevance {
+			t.Errorf("Expected BadOrderFieldError, got %v", err)
+		}
+		return
+	}
+	if err != nil || userIds(res.Users) != "34,8,23" || !res.NextPage {
+		t.Fatalf("Unexpected users by relevance %+v %v", res, err)
+	}
+
+	// with typos, by pages
+	req.Query, req.Limit = "Boid wolff", 1
+	for _, paging := range []bool{false, true} {
+		req.CursorPaging = paging
+		users, err := client.FindAllUsers(context.Background(), req, 0)
+		if err != nil || userIds(users) != "0" {
+			t.Errorf("%+v: unexpected users %+v %v", req, users, err)
+		}
+	}
+	res, err = client.FindUsers(SearchRequest{Limit: 2, Query: "lorem", Order: []SortKey{{OrderFieldRelevance, true}}})
+	if err != nil || userIds(res.Users) != "12,11" {
+		t.Errorf("Unexpected users by -relevance %+v %v", res, err)
+	}
+}
+
+func TestServerPackageUnauthorized(t *testing.T) {
+	users, err := server.LoadDataset(XMLDataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, closeServer := newServerClient(t, users, "other")
+	defer closeServer()
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+}
+
+// hasSQLite reports if the SQLite driver is built in, it needs cgo
+func hasSQLite() bool {
+	for _, driver := range sql.Drivers() {
+		if driver == "sqlite3" {
+			return true
+		}
+	}
+	return false
+}
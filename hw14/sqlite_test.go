@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// the tests of SQL run with SQLite where it builds, see hasSQLite
+import _ "github.com/mattn/go-sqlite3"